@@ -0,0 +1,349 @@
+package hooks
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Audit log actions.
+const (
+	auditRoleChange        = "role.change"
+	auditHomeownerCrown    = "homeowner.crown"
+	auditTransferStart     = "homeowner.transfer.start"
+	auditTransferCancel    = "homeowner.transfer.cancel"
+	auditHomeownerTransfer = "homeowner.transfer"
+)
+
+// Homeowner transfer states.
+const (
+	transferPending   = "pending"
+	transferAccepted  = "accepted"
+	transferCancelled = "cancelled"
+)
+
+// homeownerTransferTTL is how long the recipient has to accept a transfer.
+const homeownerTransferTTL = 24 * time.Hour
+
+var (
+	errTransferNotPending = errors.New("This transfer is no longer pending")
+	errTransferExpired    = errors.New("This transfer has expired")
+)
+
+// RegisterAdmin sets up the Homeowner's admin API: promoting and demoting Keyholders,
+// and a two-step Homeowner transfer (the Homeowner offers, the recipient accepts).
+// Every role change lands in the audit_log collection.
+func RegisterAdmin(app *pocketbase.PocketBase) {
+	app.OnServe().BindFunc(adminRoutes)
+}
+
+// adminRoutes registers the admin endpoints.
+func adminRoutes(se *core.ServeEvent) error {
+	// POST /api/hearth/admin/users/{id}/role
+	// Body: { "role": "keyholder" | "member" }
+	// Homeowner only. The Homeowner role itself moves only by transfer.
+	se.Router.POST("/api/hearth/admin/users/{id}/role", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		data := struct {
+			Role string `json:"role"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+
+		if info.Auth.GetString("role") != "homeowner" {
+			return e.ForbiddenError("Only the Homeowner can change roles", nil)
+		}
+
+		target, err := e.App.FindRecordById("users", e.Request.PathValue("id"))
+		if err != nil {
+			return e.NotFoundError("User not found", nil)
+		}
+
+		if err := validateRoleChange(info.Auth.Id, target.Id, target.GetString("role"), data.Role, target.GetBool("guest")); err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		previous := target.GetString("role")
+		if previous == data.Role {
+			return e.JSON(200, map[string]any{"user_id": target.Id, "role": previous})
+		}
+
+		err = e.App.RunInTransaction(func(txApp core.App) error {
+			target.Set("role", data.Role)
+			if err := txApp.Save(target); err != nil {
+				return err
+			}
+			return writeAudit(txApp, auditRoleChange, info.Auth.Id, target, map[string]any{
+				"from": previous,
+				"to":   data.Role,
+			})
+		})
+		if err != nil {
+			return e.BadRequestError("Failed to change role", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"user_id": target.Id,
+			"role":    data.Role,
+		})
+	}).Bind(apis.RequireAuth())
+
+	// POST /api/hearth/admin/transfer
+	// Body: { "user_id": "..." }
+	// Step 1: the Homeowner offers the House to another user. Replaces any pending offer.
+	se.Router.POST("/api/hearth/admin/transfer", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		data := struct {
+			UserID string `json:"user_id"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+
+		if info.Auth.GetString("role") != "homeowner" {
+			return e.ForbiddenError("Only the Homeowner can transfer the House", nil)
+		}
+
+		target, err := e.App.FindRecordById("users", data.UserID)
+		if err != nil {
+			return e.NotFoundError("User not found", nil)
+		}
+		if target.Id == info.Auth.Id {
+			return e.BadRequestError("You are already the Homeowner", nil)
+		}
+		if target.GetBool("guest") {
+			return e.BadRequestError("Guests can't become the Homeowner", nil)
+		}
+
+		transfersCol, err := e.App.FindCollectionByNameOrId("homeowner_transfers")
+		if err != nil {
+			return e.InternalServerError("Transfers not configured", err)
+		}
+
+		transfer := core.NewRecord(transfersCol)
+		err = e.App.RunInTransaction(func(txApp core.App) error {
+			// One offer at a time
+			if _, err := txApp.DB().NewQuery(
+				"UPDATE homeowner_transfers SET status = {:cancelled} WHERE status = {:pending}",
+			).Bind(dbx.Params{"cancelled": transferCancelled, "pending": transferPending}).Execute(); err != nil {
+				return err
+			}
+
+			transfer.Set("from", info.Auth.Id)
+			transfer.Set("to", target.Id)
+			transfer.Set("status", transferPending)
+			transfer.Set("expires_at", time.Now().Add(homeownerTransferTTL).UTC().Format(time.RFC3339))
+			if err := txApp.Save(transfer); err != nil {
+				return err
+			}
+
+			return writeAudit(txApp, auditTransferStart, info.Auth.Id, target, map[string]any{
+				"transfer_id": transfer.Id,
+			})
+		})
+		if err != nil {
+			return e.BadRequestError("Failed to start transfer", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"transfer_id": transfer.Id,
+			"to":          target.Id,
+			"status":      transferPending,
+			"expires_at":  transfer.GetDateTime("expires_at").Time().Format(time.RFC3339),
+		})
+	}).Bind(apis.RequireAuth())
+
+	// POST /api/hearth/admin/transfer/{id}/accept
+	// Step 2: the recipient confirms. The outgoing Homeowner stays on as a Keyholder.
+	se.Router.POST("/api/hearth/admin/transfer/{id}/accept", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		transfer, err := e.App.FindRecordById("homeowner_transfers", e.Request.PathValue("id"))
+		if err != nil || transfer.GetString("to") != info.Auth.Id {
+			return e.NotFoundError("Transfer not found", nil)
+		}
+
+		if err := transferUsable(transfer.GetString("status"), transfer.GetDateTime("expires_at").Time(), time.Now()); err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		err = e.App.RunInTransaction(func(txApp core.App) error {
+			from, err := txApp.FindRecordById("users", transfer.GetString("from"))
+			if err != nil || from.GetString("role") != "homeowner" {
+				return errors.New("the offering user is no longer the Homeowner")
+			}
+
+			to, err := txApp.FindRecordById("users", info.Auth.Id)
+			if err != nil {
+				return err
+			}
+
+			from.Set("role", "keyholder")
+			if err := txApp.Save(from); err != nil {
+				return err
+			}
+			to.Set("role", "homeowner")
+			if err := txApp.Save(to); err != nil {
+				return err
+			}
+
+			transfer.Set("status", transferAccepted)
+			if err := txApp.Save(transfer); err != nil {
+				return err
+			}
+
+			return writeAudit(txApp, auditHomeownerTransfer, from.Id, to, map[string]any{
+				"transfer_id": transfer.Id,
+				"from_role":   "keyholder",
+			})
+		})
+		if err != nil {
+			return e.BadRequestError("Failed to accept transfer", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"transfer_id": transfer.Id,
+			"status":      transferAccepted,
+		})
+	}).Bind(apis.RequireAuth())
+
+	// POST /api/hearth/admin/transfer/{id}/cancel
+	// Either side can back out while the transfer is pending.
+	se.Router.POST("/api/hearth/admin/transfer/{id}/cancel", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		transfer, err := e.App.FindRecordById("homeowner_transfers", e.Request.PathValue("id"))
+		if err != nil || (transfer.GetString("from") != info.Auth.Id && transfer.GetString("to") != info.Auth.Id) {
+			return e.NotFoundError("Transfer not found", nil)
+		}
+
+		if transfer.GetString("status") != transferPending {
+			return e.BadRequestError(errTransferNotPending.Error(), nil)
+		}
+
+		err = e.App.RunInTransaction(func(txApp core.App) error {
+			transfer.Set("status", transferCancelled)
+			if err := txApp.Save(transfer); err != nil {
+				return err
+			}
+
+			to, _ := txApp.FindRecordById("users", transfer.GetString("to"))
+			return writeAudit(txApp, auditTransferCancel, info.Auth.Id, to, map[string]any{
+				"transfer_id": transfer.Id,
+			})
+		})
+		if err != nil {
+			return e.BadRequestError("Failed to cancel transfer", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"transfer_id": transfer.Id,
+			"status":      transferCancelled,
+		})
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// validateRoleChange checks a Homeowner's promote/demote request.
+func validateRoleChange(actorID, targetID, targetRole, newRole string, targetGuest bool) error {
+	switch {
+	case newRole != "keyholder" && newRole != "member":
+		return errors.New("role must be keyholder or member")
+	case targetID == actorID:
+		return errors.New("You can't change your own role — transfer the House instead")
+	case targetRole == "homeowner":
+		return errors.New("The Homeowner role moves only by transfer")
+	case targetGuest:
+		return errors.New("Guests can't hold House roles")
+	}
+	return nil
+}
+
+// transferUsable reports whether a Homeowner transfer can still be accepted.
+func transferUsable(status string, expiresAt, now time.Time) error {
+	if status != transferPending {
+		return errTransferNotPending
+	}
+	if !now.Before(expiresAt) {
+		return errTransferExpired
+	}
+	return nil
+}
+
+// writeAudit appends an entry to the audit log. actorID is empty for system
+// actions; target may be nil. The target's name is copied so the entry stays
+// readable after the account is gone.
+func writeAudit(app core.App, action, actorID string, target *core.Record, details map[string]any) error {
+	col, err := app.FindCollectionByNameOrId("audit_log")
+	if err != nil {
+		return err
+	}
+
+	entry := core.NewRecord(col)
+	entry.Set("action", action)
+	if actorID != "" {
+		entry.Set("actor", actorID)
+	}
+	if target != nil {
+		entry.Set("target", target.Id)
+		entry.Set("target_name", target.GetString("display_name"))
+	}
+	entry.Set("details", details)
+
+	return app.Save(entry)
+}
+
+// homeownerEverCrowned reports whether this House has ever had a Homeowner,
+// according to the audit log.
+func homeownerEverCrowned(app core.App) (bool, error) {
+	total, err := app.CountRecords("audit_log", dbx.In("action", auditHomeownerCrown, auditHomeownerTransfer))
+	return total > 0, err
+}
+
+// crownHomeowner makes user the Homeowner — once per House. After a transfer, or
+// once the original Homeowner is deleted, nobody is silently crowned in their place.
+func crownHomeowner(app core.App, user *core.Record, reason string) (bool, error) {
+	crowned, err := homeownerEverCrowned(app)
+	if err != nil || crowned {
+		return false, err
+	}
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		user.Set("role", "homeowner")
+		if err := txApp.Save(user); err != nil {
+			return err
+		}
+		return writeAudit(txApp, auditHomeownerCrown, "", user, map[string]any{"reason": reason})
+	})
+	return err == nil, err
+}
+
+// backfillHomeowner crowns the oldest (non-guest) user on installs that predate roles.
+// An existing Homeowner is recorded in the audit log instead, which also keeps the
+// backfill from ever running again.
+func backfillHomeowner(app core.App) error {
+	crowned, err := homeownerEverCrowned(app)
+	if err != nil || crowned {
+		return err
+	}
+
+	if owner, err := app.FindFirstRecordByFilter("users", "role = 'homeowner'"); err == nil {
+		return writeAudit(app, auditHomeownerCrown, "", owner, map[string]any{"reason": "existing"})
+	}
+
+	oldest, err := app.FindRecordsByFilter("users", "guest != true", "created", 1, 0)
+	if err != nil || len(oldest) == 0 {
+		return err
+	}
+
+	_, err = crownHomeowner(app, oldest[0], "backfill")
+	return err
+}
@@ -0,0 +1,267 @@
+package hooks
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	_ "golang.org/x/image/webp" // registers the WebP decoder for image.DecodeConfig
+)
+
+// Attachment limits. Images are re-encoded in memory, so the pixel cap stops a
+// small file that decodes to a huge bitmap from eating the memory budget.
+const (
+	maxAttachmentSize = 10 << 20   // bytes per file
+	maxImagePixels    = 16_000_000 // width × height (per frame), avatars too
+	maxGIFFrames      = 500
+	maxGIFPixels      = 4 * maxImagePixels // summed over every frame of an animated GIF
+)
+
+// attachmentMimeTypes is the upload allowlist, enforced by the file field.
+var attachmentMimeTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"text/plain",
+}
+
+// attachmentThumbs are the thumbnail sizes PocketBase generates (with imaging)
+// the first time a client asks for one: a square crop for the message list and
+// a width-bound preview.
+var attachmentThumbs = []string{"160x160", "480x0"}
+
+// RegisterAttachments sets up file attachments on messages. Each attachment is a
+// record pointing at its message; uploads are stripped of metadata and counted
+// against per-user and House quotas. Attachments cascade with their message —
+// the message GC deletes them (and so their files) before sweeping the row.
+func RegisterAttachments(app *pocketbase.PocketBase) {
+	bindAttachmentHooks(app)
+}
+
+// bindAttachmentHooks binds the upload hook.
+func bindAttachmentHooks(app core.App) {
+	app.OnRecordCreateRequest("attachments").BindFunc(func(e *core.RecordRequestEvent) error {
+		files := e.Record.GetUnsavedFiles("file")
+		if len(files) != 1 || files[0].Size > maxAttachmentSize {
+			// Missing or oversized — the file field's own validation reports it
+			return e.Next()
+		}
+
+		cleaned, width, height, err := stripImageMetadata(files[0])
+		if err != nil {
+			return attachmentError("validation_attachment_image", "This image can't be uploaded: "+err.Error())
+		}
+		e.Record.Set("file", cleaned)
+		e.Record.Set("size", cleaned.Size)
+		e.Record.Set("width", width)
+		e.Record.Set("height", height)
+
+		if err := checkAttachmentQuota(e.App, e.Record.GetString("uploader"), cleaned.Size); err != nil {
+			return err
+		}
+
+		return e.Next()
+	})
+}
+
+// stripImageMetadata re-encodes an uploaded image so EXIF (GPS included), XMP and
+// comments never reach storage. JPEG orientation is applied first so the picture
+// still faces the right way; WebP, which Go can't encode, is stored as PNG.
+// Anything that isn't an image passes through untouched and the MIME allowlist
+// decides whether it's accepted. Returns the image's dimensions (0 for non-images).
+func stripImageMetadata(file *filesystem.File) (*filesystem.File, int, int, error) {
+	data, err := readUploadedFile(file)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return file, 0, 0, nil
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, 0, 0, errors.New("image is too large")
+	}
+
+	var out bytes.Buffer
+	name := file.OriginalName
+	width, height := config.Width, config.Height
+
+	switch format {
+	case "gif":
+		// Frames are tiny compressed and full-size decoded, so count them first
+		frames, pixels, err := gifFrameSizes(data)
+		if err != nil {
+			return nil, 0, 0, errors.New("image could not be read")
+		}
+		if frames > maxGIFFrames || pixels > maxGIFPixels {
+			return nil, 0, 0, errors.New("animation is too long")
+		}
+
+		// Re-encoding keeps the frames and loop count and drops every extension block
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, 0, 0, errors.New("image could not be read")
+		}
+		if err := gif.EncodeAll(&out, anim); err != nil {
+			return nil, 0, 0, err
+		}
+	case "jpeg", "png", "webp":
+		img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+		if err != nil {
+			return nil, 0, 0, errors.New("image could not be read")
+		}
+		encoding := imaging.PNG
+		if format == "jpeg" {
+			encoding = imaging.JPEG
+		} else {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ".png"
+		}
+		if err := imaging.Encode(&out, img, encoding, imaging.JPEGQuality(90)); err != nil {
+			return nil, 0, 0, err
+		}
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	default:
+		return file, 0, 0, nil
+	}
+
+	cleaned, err := filesystem.NewFileFromBytes(out.Bytes(), name)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return cleaned, width, height, nil
+}
+
+// gifFrameSizes walks a GIF's blocks without decoding any image data and returns
+// how many frames it has and their total area in pixels.
+func gifFrameSizes(data []byte) (frames, pixels int, err error) {
+	errTruncated := errors.New("truncated GIF")
+
+	// Header and logical screen descriptor, then the global color table if any
+	if len(data) < 13 {
+		return 0, 0, errTruncated
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+
+	// skipSubBlocks moves past a run of length-prefixed sub-blocks and its terminator
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errTruncated
+			}
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: introducer, label, sub-blocks
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor: position, size, flags, local color table, LZW data
+			if pos+10 > len(data) {
+				return 0, 0, errTruncated
+			}
+			width := int(data[pos+5]) | int(data[pos+6])<<8
+			height := int(data[pos+7]) | int(data[pos+8])<<8
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			pos++ // LZW minimum code size
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			pixels += width * height
+		case 0x3B: // trailer
+			return frames, pixels, nil
+		default:
+			return 0, 0, errors.New("malformed GIF")
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+// readUploadedFile reads an uploaded file into memory.
+func readUploadedFile(file *filesystem.File) ([]byte, error) {
+	r, err := file.Reader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// checkAttachmentQuota rejects an upload of size bytes that would take the
+// uploader or the House past its attachment quota.
+func checkAttachmentQuota(app core.App, uploaderID string, size int64) error {
+	used, err := attachmentBytes(app, dbx.HashExp{"uploader": uploaderID})
+	if err != nil {
+		return err
+	}
+	if used+size > attachmentQuota("ATTACHMENT_QUOTA_USER_MB", 100) {
+		return attachmentError("validation_attachment_user_quota", "You're out of attachment space — remove some old files first")
+	}
+
+	used, err = attachmentBytes(app, nil)
+	if err != nil {
+		return err
+	}
+	if used+size > attachmentQuota("ATTACHMENT_QUOTA_HOUSE_MB", 2048) {
+		return attachmentError("validation_attachment_house_quota", "This House is out of attachment space")
+	}
+
+	return nil
+}
+
+// attachmentBytes sums the size of the attachments matching where (all of them if nil).
+func attachmentBytes(app core.App, where dbx.Expression) (int64, error) {
+	var total int64
+	err := app.DB().
+		Select("COALESCE(SUM(size), 0)").
+		From("attachments").
+		Where(where).
+		Row(&total)
+	return total, err
+}
+
+// attachmentQuota reads a quota in megabytes (0 – 1048576) from env, falling
+// back to defMB when unset or out of range. A quota of 0 turns uploads off.
+func attachmentQuota(key string, defMB int64) int64 {
+	mb, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || mb < 0 || mb > 1048576 {
+		mb = defMB
+	}
+	return mb << 20
+}
+
+// attachmentError is a field error on file, surfaced as a 400 by the records API.
+func attachmentError(code, message string) error {
+	return validation.Errors{"file": validation.NewError(code, message)}
+}
@@ -1,9 +1,11 @@
 package hooks
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
 )
@@ -38,8 +40,45 @@ func RegisterAuth(app *pocketbase.PocketBase) {
 		return e.Next()
 	})
 
+	// Registration gate (Client Puzzle Protocol): a sign-up must spend an unspent
+	// PoW token and carry a valid invite. Bound to the create *request* rather than
+	// OnRecordCreate so server-side creations (guest accounts, the superuser
+	// dashboard) aren't gated.
+	app.OnRecordCreateRequest("users").BindFunc(func(e *core.RecordRequestEvent) error {
+		if e.HasSuperuserAuth() {
+			return e.Next()
+		}
+
+		// Bootstrap: the first account (the Homeowner) has nobody to invite them
+		total, err := e.App.CountRecords("users")
+		if err == nil && total == 0 {
+			return e.Next()
+		}
+
+		info, err := e.RequestInfo()
+		if err != nil {
+			return e.BadRequestError("Invalid request", err)
+		}
+
+		refund, err := checkRegistrationGate(e.App, info.Body)
+		if err != nil {
+			return e.ForbiddenError(err.Error(), nil)
+		}
+
+		// A sign-up that fails to save (say, a taken email) gets its token and
+		// invite use back. They're spent up front so two sign-ups can't share them.
+		if err := e.Next(); err != nil {
+			if e.Record.IsNew() {
+				refund()
+			}
+			return err
+		}
+		return nil
+	})
+
 	// Before message creation: server-side TTL enforcement
-	// Clients cannot set their own expires_at — the server overrides it
+	// Clients cannot set their own expires_at — the server overrides it. They
+	// may ask for a shorter life (ttl), never a longer one.
 	app.OnRecordCreate("messages").BindFunc(func(e *core.RecordEvent) error {
 		roomID := e.Record.GetString("room")
 		if roomID == "" {
@@ -51,14 +90,12 @@ func RegisterAuth(app *pocketbase.PocketBase) {
 			return err
 		}
 
-		ttlSeconds := room.GetInt("default_ttl")
-		if ttlSeconds > 0 {
-			// Campfire: messages expire after TTL
-			expiresAt := time.Now().Add(time.Duration(ttlSeconds) * time.Second)
-			e.Record.Set("expires_at", expiresAt.UTC().Format(time.RFC3339))
-		} else {
-			// Den: messages are permanent — far-future expiry keeps GC query simple
-			e.Record.Set("expires_at", "2099-12-31T23:59:59Z")
+		e.Record.Set("expires_at", messageExpiresAt(room, e.Record.GetInt("ttl"), time.Now()))
+
+		if e.Record.GetInt("burn_after_read") > 0 && room.GetInt("default_ttl") == 0 {
+			return validation.Errors{"burn_after_read": validation.NewError(
+				"validation_burn_after_read_den", "Burn after read is for campfire messages",
+			)}
 		}
 
 		// Default message type to "text"
@@ -103,11 +140,14 @@ func RegisterAuth(app *pocketbase.PocketBase) {
 		return e.Next()
 	})
 
-	// Before room creation: default type to campfire if not specified
+	// Before room creation: default type to campfire and visibility to open if not specified
 	app.OnRecordCreate("rooms").BindFunc(func(e *core.RecordEvent) error {
 		if e.Record.GetString("type") == "" {
 			e.Record.Set("type", "campfire")
 		}
+		if e.Record.GetString("visibility") == "" {
+			e.Record.Set("visibility", roomVisibilityOpen)
+		}
 		return e.Next()
 	})
 
@@ -129,13 +169,18 @@ func RegisterAuth(app *pocketbase.PocketBase) {
 			return e.Next()
 		}
 
-		// This is the first user — crown as homeowner
-		e.Record.Set("role", "homeowner")
-		if err := e.App.Save(e.Record); err != nil {
+		// This is the first user — crown as homeowner (unless the House already had
+		// one: a transferred or deleted Homeowner is never silently replaced)
+		crowned, err := crownHomeowner(e.App, e.Record, "first_user")
+		if err != nil {
 			e.App.Logger().Error("failed to crown first user as homeowner", "error", err)
-		} else {
-			e.App.Logger().Info("first user crowned as Homeowner", "user", e.Record.Id)
+			return e.Next()
+		}
+		if !crowned {
+			e.App.Logger().Warn("first user not crowned: this House already had a Homeowner", "user", e.Record.Id)
+			return e.Next()
 		}
+		e.App.Logger().Info("first user crowned as Homeowner", "user", e.Record.Id)
 
 		// Seed "The Den" now that we have a homeowner
 		seedDefaultDen(e.App, e.Record.Id)
@@ -160,6 +205,79 @@ func RegisterAuth(app *pocketbase.PocketBase) {
 	})
 }
 
+// messageExpiresAt is the expires_at for a message posted to room at now. A
+// requested ttl (seconds, 0 for none) can only burn a message faster than the
+// room's TTL would.
+func messageExpiresAt(room *core.Record, requestedTTL int, now time.Time) string {
+	ttlSeconds := room.GetInt("default_ttl")
+	if requestedTTL > 0 && (ttlSeconds == 0 || requestedTTL < ttlSeconds) {
+		ttlSeconds = requestedTTL
+	}
+	return expiresAfter(ttlSeconds, now)
+}
+
+// permanentExpiry is the expires_at of a message that never fades — a far-future
+// expiry keeps the GC query simple.
+const permanentExpiry = "2099-12-31T23:59:59Z"
+
+// expiresAfter is the expires_at for a message with a TTL of ttlSeconds posted
+// at now (0 = permanent, as in dens and DMs without a timer).
+func expiresAfter(ttlSeconds int, now time.Time) string {
+	if ttlSeconds > 0 {
+		return now.Add(time.Duration(ttlSeconds) * time.Second).UTC().Format(time.RFC3339)
+	}
+	return permanentExpiry
+}
+
+// checkRegistrationGate verifies the invite (invite_k, or v1 invite_r, invite_t, invite_s,
+// invite_i) and spends the PoW token (pow_token) presented in a sign-up body. The invite is
+// checked first so a bad link doesn't burn the caller's token; a tracked invite's use is spent last.
+// On success it returns refund, which hands both back if the sign-up then fails.
+func checkRegistrationGate(app core.App, body map[string]any) (refund func(), err error) {
+	invite := inviteParams{
+		Token:     bodyString(body, "invite_k"),
+		RoomSlug:  bodyString(body, "invite_r"),
+		Timestamp: bodyString(body, "invite_t"),
+		Signature: bodyString(body, "invite_s"),
+		InviteID:  bodyString(body, "invite_i"),
+	}
+
+	if _, err := checkInvite(app, invite); err != nil {
+		return nil, errors.New("A valid invite is required to join this House")
+	}
+
+	powToken := bodyString(body, "pow_token")
+	tokenExpiresAt, ok := powChallenges.spendToken(powToken)
+	if !ok {
+		return nil, errors.New("A valid proof-of-work token is required")
+	}
+
+	claims, err := redeemInvite(app, invite)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		powChallenges.issueToken(powToken, tokenExpiresAt)
+		if err := unredeemInvite(app, claims); err != nil {
+			app.Logger().Error("failed to refund invite use", "error", err, "invite", claims.InviteID)
+		}
+	}, nil
+}
+
+// bodyString reads a string value from a decoded request body, or "" if absent.
+func bodyString(body map[string]any, key string) string {
+	switch v := body[key].(type) {
+	case string:
+		return v
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
 // seedDefaultDen creates "The Den" if no dens exist yet.
 func seedDefaultDen(app core.App, ownerID string) {
 	// Check if any dens already exist
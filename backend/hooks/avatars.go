@@ -0,0 +1,120 @@
+package hooks
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Avatars are stored as one avatarSize square; the smaller sizes the UI asks for
+// are PocketBase thumbs of it (?thumb=64x64), generated once and kept as files.
+const (
+	avatarSize        = 256
+	maxAvatarFileSize = 5 << 20
+)
+
+// avatarThumbs are the avatar sizes besides the stored square (2× the UI's sm and md).
+var avatarThumbs = []string{"64x64", "128x128"}
+
+// RegisterAvatars sets up uploaded avatars and the avatar_url policy. Uploads are
+// cropped to a centred square, resized and re-encoded (dropping EXIF and every
+// other metadata block). The old avatar_url hotlinks the image from wherever the
+// user points it, telling that host who's online and when — so it's accepted and
+// shown only while house_settings.external_avatars is on.
+func RegisterAvatars(app *pocketbase.PocketBase) {
+	bindAvatarHooks(app)
+}
+
+// bindAvatarHooks binds the avatar hooks.
+func bindAvatarHooks(app core.App) {
+	app.OnRecordCreateRequest("users").BindFunc(processAvatarRequest)
+	app.OnRecordUpdateRequest("users").BindFunc(processAvatarRequest)
+
+	// Hide URLs set before the Homeowner turned external avatars off
+	app.OnRecordEnrich("users").BindFunc(func(e *core.RecordEnrichEvent) error {
+		if e.Record.GetString("avatar_url") != "" && !externalAvatarsAllowed(e.App) {
+			e.Record.Set("avatar_url", "")
+		}
+		return e.Next()
+	})
+}
+
+// processAvatarRequest squares up an uploaded avatar and refuses a new avatar_url
+// while external avatars are off.
+func processAvatarRequest(e *core.RecordRequestEvent) error {
+	url := e.Record.GetString("avatar_url")
+	changed := url != "" && (e.Record.IsNew() || url != e.Record.Original().GetString("avatar_url"))
+	if changed && !externalAvatarsAllowed(e.App) {
+		return validation.Errors{"avatar_url": validation.NewError(
+			"validation_external_avatars_off", "This House doesn't allow external avatars — upload one instead",
+		)}
+	}
+
+	files := e.Record.GetUnsavedFiles("avatar")
+	if len(files) != 1 || files[0].Size > maxAvatarFileSize {
+		// Nothing new, or oversized — the file field's own validation reports it
+		return e.Next()
+	}
+
+	square, err := squareAvatar(files[0])
+	if err != nil {
+		return validation.Errors{"avatar": validation.NewError(
+			"validation_avatar_image", "This avatar can't be uploaded: "+err.Error(),
+		)}
+	}
+	e.Record.Set("avatar", square)
+
+	return e.Next()
+}
+
+// squareAvatar crops an uploaded image to a centred square and resizes it to
+// avatarSize. The result is freshly encoded — JPEG for photos, PNG for the rest
+// (keeping transparency; animated GIFs keep their first frame).
+func squareAvatar(file *filesystem.File) (*filesystem.File, error) {
+	data, err := readUploadedFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("not an image")
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, errors.New("image is too large")
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, errors.New("image could not be read")
+	}
+	img = imaging.Fill(img, avatarSize, avatarSize, imaging.Center, imaging.Lanczos)
+
+	encoding, ext := imaging.PNG, ".png"
+	if format == "jpeg" {
+		encoding, ext = imaging.JPEG, ".jpg"
+	}
+
+	var out bytes.Buffer
+	if err := imaging.Encode(&out, img, encoding, imaging.JPEGQuality(85)); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(file.OriginalName, filepath.Ext(file.OriginalName)) + ext
+	return filesystem.NewFileFromBytes(out.Bytes(), name)
+}
+
+// externalAvatarsAllowed reports whether the House accepts and shows avatar_url.
+func externalAvatarsAllowed(app core.App) bool {
+	total, err := app.CountRecords("house_settings", dbx.HashExp{"external_avatars": true})
+	return err == nil && total > 0
+}
@@ -0,0 +1,95 @@
+package hooks
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Refusals caused by a block read like any other, so the blocked user can't
+// tell they've been blocked.
+const (
+	dmUnavailableMessage    = "You can't message this user"
+	voiceUnavailableMessage = "Voice isn't available in this room right now"
+)
+
+// RegisterBlocks enforces user_blocks. A block in either direction closes the
+// pair's DMs — neither can open one or write in one they already have. The
+// blocked user stops seeing the blocker in room presence, and a blocker who sets
+// no_shared_voice is never put in a voice call with them: whichever of the two
+// asks for a LiveKit token second is turned away.
+func RegisterBlocks(app *pocketbase.PocketBase) {
+	bindBlockHooks(app)
+}
+
+// bindBlockHooks binds the DM write check. Opening DMs, presence and voice are
+// checked in their own handlers.
+func bindBlockHooks(app core.App) {
+	app.OnRecordCreateRequest("dm_messages").BindFunc(func(e *core.RecordRequestEvent) error {
+		if e.Auth == nil || e.HasSuperuserAuth() {
+			return e.Next()
+		}
+
+		dm, err := e.App.FindRecordById("direct_messages", e.Record.GetString("dm"))
+		if err != nil {
+			// No such DM — the relation field's own validation reports it
+			return e.Next()
+		}
+		if blockedEitherWay(e.App, dm.GetString("participant_a"), dm.GetString("participant_b")) {
+			return e.ForbiddenError(dmUnavailableMessage, nil)
+		}
+
+		return e.Next()
+	})
+}
+
+// hasBlocked reports whether blockerID has blocked userID.
+func hasBlocked(app core.App, blockerID, userID string) bool {
+	total, err := app.CountRecords("user_blocks", dbx.HashExp{"blocker": blockerID, "blocked": userID})
+	return err == nil && total > 0
+}
+
+// blockedEitherWay reports whether either user has blocked the other.
+func blockedEitherWay(app core.App, userID, otherID string) bool {
+	return hasBlocked(app, userID, otherID) || hasBlocked(app, otherID, userID)
+}
+
+// blockersOf returns the users who have blocked userID.
+func blockersOf(app core.App, userID string) (map[string]bool, error) {
+	blocks, err := app.FindAllRecords("user_blocks", dbx.HashExp{"blocked": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := make(map[string]bool, len(blocks))
+	for _, block := range blocks {
+		blockers[block.GetString("blocker")] = true
+	}
+	return blockers, nil
+}
+
+// voiceBlocked reports whether userID joining roomID's voice would put them in a
+// call with someone present there on the other side of a no_shared_voice block.
+func voiceBlocked(app core.App, roomID, userID string) (bool, error) {
+	present := []any{}
+	for _, entry := range presence.GetRoomPresence(roomID) {
+		if entry.UserID != userID {
+			present = append(present, entry.UserID)
+		}
+	}
+	if len(present) == 0 {
+		return false, nil
+	}
+
+	total, err := app.CountRecords("user_blocks",
+		dbx.HashExp{"no_shared_voice": true},
+		dbx.Or(
+			dbx.And(dbx.HashExp{"blocker": userID}, dbx.In("blocked", present...)),
+			dbx.And(dbx.HashExp{"blocked": userID}, dbx.In("blocker", present...)),
+		),
+	)
+	if err != nil {
+		return false, err
+	}
+	return total > 0, nil
+}
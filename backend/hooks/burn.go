@@ -0,0 +1,162 @@
+package hooks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// maxBurnAfterRead caps messages.burn_after_read (seconds).
+const maxBurnAfterRead = 3600
+
+// ReadAckMap tracks who has read each burn-after-read message.
+// Not persisted to SQLite — like presence, read state is ephemeral by design:
+// after a restart a message simply keeps its normal TTL.
+type ReadAckMap struct {
+	mu   sync.Mutex
+	acks map[string]map[string]struct{} // key: messageID → reader userIDs
+}
+
+// Global read-ack map — singleton for the lifetime of the process.
+var readAcks = &ReadAckMap{
+	acks: make(map[string]map[string]struct{}),
+}
+
+// Ack records that userID has read messageID.
+func (rm *ReadAckMap) Ack(messageID, userID string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	readers, ok := rm.acks[messageID]
+	if !ok {
+		readers = make(map[string]struct{})
+		rm.acks[messageID] = readers
+	}
+	readers[userID] = struct{}{}
+}
+
+// HasRead reports whether userID has read messageID.
+func (rm *ReadAckMap) HasRead(messageID, userID string) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	_, ok := rm.acks[messageID][userID]
+	return ok
+}
+
+// Pending returns the messages with at least one read ack.
+func (rm *ReadAckMap) Pending() []string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	ids := make([]string, 0, len(rm.acks))
+	for id := range rm.acks {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Forget drops a message's read acks.
+func (rm *ReadAckMap) Forget(messageID string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.acks, messageID)
+}
+
+// RegisterBurnAfterRead sets up read acks for burn-after-read campfire messages.
+// Once everyone present in the room (other than the author) has read such a
+// message, its expires_at is pulled in to burn_after_read seconds from then and
+// the message GC takes it on its next pass.
+func RegisterBurnAfterRead(app *pocketbase.PocketBase) {
+	app.OnServe().BindFunc(burnRoutes)
+}
+
+// burnRoutes registers the read-ack endpoint.
+func burnRoutes(se *core.ServeEvent) error {
+	// POST /api/hearth/messages/{id}/read
+	// Returns: { "ok": true, "expires_at": "..." } — expires_at moves in once everyone present has read it.
+	// Open to whoever can view the message (room members).
+	se.Router.POST("/api/hearth/messages/{id}/read", func(e *core.RequestEvent) error {
+		info, err := e.RequestInfo()
+		if err != nil {
+			return e.BadRequestError("Invalid request", err)
+		}
+
+		message, err := e.App.FindRecordById("messages", e.Request.PathValue("id"))
+		if err != nil {
+			return e.NotFoundError("Message not found", nil)
+		}
+
+		canView, err := e.App.CanAccessRecord(message, info, message.Collection().ViewRule)
+		if !canView {
+			return e.NotFoundError("Message not found", err)
+		}
+
+		if message.GetInt("burn_after_read") > 0 && info.Auth.Id != message.GetString("author") {
+			readAcks.Ack(message.Id, info.Auth.Id)
+			if _, err := burnIfRead(e.App, message, time.Now()); err != nil {
+				return e.InternalServerError("Failed to update message", err)
+			}
+		}
+
+		return e.JSON(200, map[string]any{
+			"ok":         true,
+			"expires_at": message.GetDateTime("expires_at"),
+		})
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// burnIfRead pulls in a burn-after-read message's expires_at once every user
+// present in its room (other than the author) has acked it. Only called for
+// messages with at least one ack. A message already due to fade sooner is left
+// alone. Reports whether the message was pulled in.
+func burnIfRead(app core.App, message *core.Record, now time.Time) (bool, error) {
+	author := message.GetString("author")
+	for _, entry := range presence.GetRoomPresence(message.GetString("room")) {
+		if entry.UserID != author && !readAcks.HasRead(message.Id, entry.UserID) {
+			return false, nil
+		}
+	}
+
+	burnAt, err := types.ParseDateTime(now.Add(time.Duration(message.GetInt("burn_after_read")) * time.Second))
+	if err != nil {
+		return false, err
+	}
+	readAcks.Forget(message.Id)
+	if message.GetDateTime("expires_at").Before(burnAt) {
+		return false, nil
+	}
+
+	message.Set("expires_at", burnAt)
+	return true, app.Save(message)
+}
+
+// sweepReadAcks re-checks messages with read acks — someone leaving the room can
+// be what completes "everyone present has read it" — and forgets acks for
+// messages that are already gone.
+func sweepReadAcks(app core.App, now time.Time) int {
+	burned := 0
+	for _, id := range readAcks.Pending() {
+		message, err := app.FindRecordById("messages", id)
+		if err != nil {
+			readAcks.Forget(id)
+			continue
+		}
+
+		ok, err := burnIfRead(app, message, now)
+		if err != nil {
+			app.Logger().Error("failed to burn read message", "error", err, "message", id)
+			continue
+		}
+		if ok {
+			burned++
+		}
+	}
+	return burned
+}
@@ -0,0 +1,119 @@
+package hooks
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// auditCampfireExtinguish is the audit log action for a campfire the GC removed.
+const auditCampfireExtinguish = "campfire.extinguish"
+
+// RegisterCampfires keeps rooms.last_activity current so the message GC can put
+// out campfires that have gone quiet (see sweepIdleCampfires).
+func RegisterCampfires(app *pocketbase.PocketBase) {
+	bindCampfireHooks(app)
+}
+
+// bindCampfireHooks binds the activity hooks.
+func bindCampfireHooks(app core.App) {
+	// The idle clock starts when a room is lit
+	app.OnRecordCreate("rooms").BindFunc(func(e *core.RecordEvent) error {
+		e.Record.Set("last_activity", types.NowDateTime())
+		return e.Next()
+	})
+
+	// A message keeps its room alive until it fades. Raw UPDATE so the room's
+	// updated stamp and realtime subscribers aren't disturbed on every message.
+	app.OnRecordAfterCreateSuccess("messages").BindFunc(func(e *core.RecordEvent) error {
+		if err := touchRoomActivity(e.App, e.Record.GetString("room"), e.Record.GetDateTime("expires_at").String()); err != nil {
+			e.App.Logger().Error("failed to update room activity", "error", err, "room", e.Record.GetString("room"))
+		}
+		return e.Next()
+	})
+}
+
+// touchRoomActivity moves a room's last_activity forward to at (never back).
+func touchRoomActivity(app core.App, roomID, at string) error {
+	_, err := app.DB().
+		NewQuery("UPDATE rooms SET last_activity = MAX(COALESCE(last_activity, ''), {:at}) WHERE id = {:room}").
+		Bind(dbx.Params{"at": at, "room": roomID}).
+		Execute()
+	return err
+}
+
+// sweepIdleCampfires deletes campfires that have burned out: no live messages,
+// nobody present, and quiet (since creation or their last message fading) for
+// longer than the campfire idle TTL. Memberships, knocks and invites cascade
+// with the room. Dens are never touched. Returns how many were put out.
+func sweepIdleCampfires(app core.App, now time.Time) (int, error) {
+	nowDT, err := types.ParseDateTime(now)
+	if err != nil {
+		return 0, err
+	}
+	cutoff, err := types.ParseDateTime(now.Add(-getCampfireIdleTTL()))
+	if err != nil {
+		return 0, err
+	}
+
+	idle := []*core.Record{}
+	err = app.RecordQuery("rooms").
+		AndWhere(dbx.HashExp{"rooms.type": "campfire"}).
+		AndWhere(dbx.NewExp("[[rooms.last_activity]] != '' AND [[rooms.last_activity]] <= {:cutoff}", dbx.Params{"cutoff": cutoff.String()})).
+		AndWhere(dbx.NewExp(
+			"NOT EXISTS (SELECT 1 FROM messages WHERE messages.room = [[rooms.id]] AND messages.expires_at > {:now})",
+			dbx.Params{"now": nowDT.String()},
+		)).
+		All(&idle)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, room := range idle {
+		// Someone's still sitting by it — the quiet period starts once they leave
+		if len(presence.GetRoomPresence(room.Id)) > 0 {
+			if err := touchRoomActivity(app, room.Id, nowDT.String()); err != nil {
+				app.Logger().Error("failed to update room activity", "error", err, "room", room.Id)
+			}
+			continue
+		}
+
+		if err := app.Delete(room); err != nil {
+			app.Logger().Error("failed to extinguish campfire", "error", err, "room", room.Id)
+			continue
+		}
+		removed++
+
+		app.Logger().Info("campfire extinguished", "room", room.Id, "slug", room.GetString("slug"))
+		if err := writeAudit(app, auditCampfireExtinguish, "", nil, map[string]any{
+			"room":              room.Id,
+			"name":              room.GetString("name"),
+			"owner":             room.GetString("owner"),
+			"livekit_room_name": room.GetString("livekit_room_name"),
+		}); err != nil {
+			app.Logger().Error("failed to audit campfire extinction", "error", err, "room", room.Id)
+		}
+	}
+
+	return removed, nil
+}
+
+// getCampfireIdleTTL reads how long an empty campfire lingers from env or returns
+// the default (24 hours).
+func getCampfireIdleTTL() time.Duration {
+	s := os.Getenv("CAMPFIRE_IDLE_TTL")
+	if s == "" {
+		return 24 * time.Hour
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 300 || secs > 2592000 {
+		return 24 * time.Hour
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -3,8 +3,10 @@ package hooks
 import (
 	"fmt"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 // RegisterCollections creates the Hearth data model programmatically.
@@ -20,6 +22,9 @@ func RegisterCollections(app *pocketbase.PocketBase) {
 		if err := ensureRoomsCollection(se.App); err != nil {
 			se.App.Logger().Error("failed to create rooms collection", "error", err)
 		}
+		if err := ensureUsersGuestRoom(se.App); err != nil {
+			se.App.Logger().Error("failed to add users.guest_room", "error", err)
+		}
 		if err := ensureMessagesCollection(se.App); err != nil {
 			se.App.Logger().Error("failed to create messages collection", "error", err)
 		}
@@ -32,6 +37,36 @@ func RegisterCollections(app *pocketbase.PocketBase) {
 		if err := ensureDmMessagesCollection(se.App); err != nil {
 			se.App.Logger().Error("failed to create dm_messages collection", "error", err)
 		}
+		if err := ensureKnocksCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create knocks collection", "error", err)
+		}
+		if err := ensureInvitesCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create invites collection", "error", err)
+		}
+		if err := ensureInviteCodesCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create invite_codes collection", "error", err)
+		}
+		if err := ensureHouseSettingsCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create house_settings collection", "error", err)
+		}
+		if err := ensureAuditLogCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create audit_log collection", "error", err)
+		}
+		if err := ensureHomeownerTransfersCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create homeowner_transfers collection", "error", err)
+		}
+		if err := ensureMessageReactionsCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create message_reactions collection", "error", err)
+		}
+		if err := ensureMessageRevisionsCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create message_revisions collection", "error", err)
+		}
+		if err := ensureAttachmentsCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create attachments collection", "error", err)
+		}
+		if err := ensureUserBlocksCollection(se.App); err != nil {
+			se.App.Logger().Error("failed to create user_blocks collection", "error", err)
+		}
 
 		// Pass 2: Apply API rules now that all collections exist.
 		if err := applyAPIRules(se.App); err != nil {
@@ -41,6 +76,9 @@ func RegisterCollections(app *pocketbase.PocketBase) {
 		if err := createIndexes(se.App); err != nil {
 			se.App.Logger().Error("failed to create indexes", "error", err)
 		}
+		if err := ensureSearchIndex(se.App); err != nil {
+			se.App.Logger().Error("failed to create search index", "error", err)
+		}
 
 		// Pass 3: Backfill fields added to existing records (schema migrations).
 		if err := backfillSchemaDefaults(se.App); err != nil {
@@ -77,13 +115,27 @@ func ensureUsersFields(app core.App) error {
 		})
 	}
 
-	// Add avatar_url if missing
+	// Add avatar_url if missing (deprecated: external images leak who's looking to
+	// the host — see house_settings.external_avatars and avatar below)
 	if collection.Fields.GetByName("avatar_url") == nil {
 		collection.Fields.Add(&core.URLField{
 			Name: "avatar_url",
 		})
 	}
 
+	// Configure avatar — uploaded, cropped square and stripped (see avatars.go).
+	// PocketBase's stock users collection already has a bare avatar field.
+	avatar, _ := collection.Fields.GetByName("avatar").(*core.FileField)
+	if avatar == nil {
+		avatar = &core.FileField{Name: "avatar"}
+		collection.Fields.Add(avatar)
+	}
+	avatar.MaxSelect = 1
+	avatar.MaxSize = maxAvatarFileSize
+	avatar.MimeTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+	avatar.Thumbs = avatarThumbs
+	avatar.Protected = false
+
 	// Add status if missing
 	if collection.Fields.GetByName("status") == nil {
 		collection.Fields.Add(&core.SelectField{
@@ -102,6 +154,18 @@ func ensureUsersFields(app core.App) error {
 		})
 	}
 
+	// Guest accounts (The Knock): session-bound, scoped to one room, claimable later
+	if collection.Fields.GetByName("guest") == nil {
+		collection.Fields.Add(&core.BoolField{
+			Name: "guest",
+		})
+	}
+	if collection.Fields.GetByName("guest_expires_at") == nil {
+		collection.Fields.Add(&core.DateField{
+			Name: "guest_expires_at",
+		})
+	}
+
 	// Add public_key if missing (E2EE readiness — v1.0, empty until enrolled)
 	if collection.Fields.GetByName("public_key") == nil {
 		collection.Fields.Add(&core.TextField{
@@ -113,6 +177,31 @@ func ensureUsersFields(app core.App) error {
 	return app.Save(collection)
 }
 
+// ensureUsersGuestRoom adds the guest_room relation to users. Separate from
+// ensureUsersFields because the rooms collection must exist first.
+func ensureUsersGuestRoom(app core.App) error {
+	collection, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return err
+	}
+	if collection.Fields.GetByName("guest_room") != nil {
+		return nil
+	}
+
+	roomsCol, err := app.FindCollectionByNameOrId("rooms")
+	if err != nil {
+		return fmt.Errorf("rooms collection not found: %w", err)
+	}
+
+	collection.Fields.Add(&core.RelationField{
+		Name:         "guest_room",
+		CollectionId: roomsCol.Id,
+		MaxSelect:    1,
+	})
+
+	return app.Save(collection)
+}
+
 // ensureRoomsCollection creates the rooms collection if it doesn't exist.
 // If it does exist, adds any missing ADR-007 fields incrementally.
 func ensureRoomsCollection(app core.App) error {
@@ -157,6 +246,29 @@ func ensureRoomsCollection(app core.App) error {
 			changed = true
 		}
 
+		if existing.Fields.GetByName("keyholders") == nil {
+			usersCol, err := app.FindCollectionByNameOrId("users")
+			if err != nil {
+				return fmt.Errorf("users collection not found: %w", err)
+			}
+			existing.Fields.Add(&core.RelationField{
+				Name:         "keyholders",
+				CollectionId: usersCol.Id,
+				MaxSelect:    25,
+			})
+			changed = true
+		}
+
+		if existing.Fields.GetByName("visibility") == nil {
+			existing.Fields.Add(roomVisibilityField())
+			changed = true
+		}
+
+		if existing.Fields.GetByName("last_activity") == nil {
+			existing.Fields.Add(lastActivityField())
+			changed = true
+		}
+
 		if changed {
 			return app.Save(existing)
 		}
@@ -243,6 +355,16 @@ func ensureRoomsCollection(app core.App) error {
 		Name: "history_visible",
 	})
 
+	// Keyholders this room is delegated to — they moderate it alongside the owner
+	collection.Fields.Add(&core.RelationField{
+		Name:         "keyholders",
+		CollectionId: usersCol.Id,
+		MaxSelect:    25,
+	})
+
+	collection.Fields.Add(roomVisibilityField())
+	collection.Fields.Add(lastActivityField())
+
 	// Add unique indexes (rules applied in pass 2 via applyAPIRules)
 	collection.Indexes = []string{
 		"CREATE UNIQUE INDEX idx_rooms_slug ON rooms (slug)",
@@ -252,6 +374,26 @@ func ensureRoomsCollection(app core.App) error {
 	return app.Save(collection)
 }
 
+// roomVisibilityField is rooms.visibility: who can find the room and how they get in.
+func roomVisibilityField() *core.SelectField {
+	return &core.SelectField{
+		Name:      "visibility",
+		Values:    []string{roomVisibilityOpen, roomVisibilityKnock, roomVisibilityPrivate},
+		MaxSelect: 1,
+	}
+}
+
+// lastActivityField is rooms.last_activity: when the room was last in use — the
+// moment its latest message fades, or the last GC pass that found someone
+// present. Server-maintained (see campfires.go); hidden, so clients can't
+// keep a campfire alive by hand.
+func lastActivityField() *core.DateField {
+	return &core.DateField{
+		Name:   "last_activity",
+		Hidden: true,
+	}
+}
+
 // ensureMessagesCollection creates the messages collection if it doesn't exist.
 func ensureMessagesCollection(app core.App) error {
 	existing, err := app.FindCollectionByNameOrId("messages")
@@ -280,6 +422,25 @@ func ensureMessagesCollection(app core.App) error {
 			})
 			changed = true
 		}
+		if existing.Fields.GetByName("reaction_counts") == nil {
+			existing.Fields.Add(reactionCountsField())
+			changed = true
+		}
+		if existing.Fields.GetByName("edited") == nil {
+			existing.Fields.Add(&core.BoolField{Name: "edited"})
+			changed = true
+		}
+		if existing.Fields.GetByName("ttl") == nil {
+			existing.Fields.Add(messageTTLField())
+			changed = true
+		}
+		if existing.Fields.GetByName("burn_after_read") == nil {
+			existing.Fields.Add(burnAfterReadField())
+			changed = true
+		}
+		if addReplyFields(existing) {
+			changed = true
+		}
 		if changed {
 			return app.Save(existing)
 		}
@@ -349,15 +510,93 @@ func ensureMessagesCollection(app core.App) error {
 		OnUpdate: true,
 	})
 
+	collection.Fields.Add(reactionCountsField())
+
+	// Set by the server when the body changes (see edits.go)
+	collection.Fields.Add(&core.BoolField{Name: "edited"})
+
+	collection.Fields.Add(messageTTLField())
+	collection.Fields.Add(burnAfterReadField())
+
 	// Rules applied in pass 2 via applyAPIRules
 
+	if err := app.Save(collection); err != nil {
+		return err
+	}
+
+	// reply_to points back at this collection, so it can only be added once it exists
+	addReplyFields(collection)
 	return app.Save(collection)
 }
 
+// messageTTLField is messages.ttl: a sender-chosen lifetime in seconds, capped at
+// the room's default_ttl (0 = the room's default). See messageExpiresAt.
+func messageTTLField() *core.NumberField {
+	return &core.NumberField{
+		Name:    "ttl",
+		OnlyInt: true,
+		Min:     floatPtr(0),
+		Max:     floatPtr(86400),
+	}
+}
+
+// burnAfterReadField is messages.burn_after_read: seconds a campfire message
+// lingers once everyone present has read it (0 = off). See burn.go.
+func burnAfterReadField() *core.NumberField {
+	return &core.NumberField{
+		Name:    "burn_after_read",
+		OnlyInt: true,
+		Min:     floatPtr(0),
+		Max:     floatPtr(maxBurnAfterRead),
+	}
+}
+
+// reactionCountsField is messages.reaction_counts: per-emoji totals maintained
+// from message_reactions (see reactions.go).
+func reactionCountsField() *core.JSONField {
+	return &core.JSONField{
+		Name:    "reaction_counts",
+		MaxSize: 4000,
+	}
+}
+
+// addReplyFields adds reply_to (a relation to the same collection) and the
+// snapshot of the quoted parent to messages or dm_messages. The snapshot is
+// filled in by replies.go so a reply still renders once its parent has faded.
+// Reports whether the fields were missing.
+func addReplyFields(collection *core.Collection) bool {
+	if collection.Fields.GetByName("reply_to") != nil {
+		return false
+	}
+
+	collection.Fields.Add(&core.RelationField{
+		Name:         "reply_to",
+		CollectionId: collection.Id,
+		MaxSelect:    1,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name: "reply_author_name",
+		Max:  50,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name: "reply_excerpt",
+		Max:  replyExcerptLength + 1, // + ellipsis
+	})
+
+	return true
+}
+
 // ensureRoomMembersCollection creates the room_members join collection.
 func ensureRoomMembersCollection(app core.App) error {
-	_, err := app.FindCollectionByNameOrId("room_members")
+	existing, err := app.FindCollectionByNameOrId("room_members")
 	if err == nil {
+		// Collection exists — ensure per-room grants are present (schema migration)
+		if existing.Fields.GetByName("permissions") == nil {
+			existing.Fields.Add(roomPermissionsField())
+			return app.Save(existing)
+		}
 		return nil
 	}
 
@@ -402,6 +641,8 @@ func ensureRoomMembersCollection(app core.App) error {
 		MaxSelect:    1,
 	})
 
+	collection.Fields.Add(roomPermissionsField())
+
 	// Rules applied in pass 2 via applyAPIRules
 
 	// Unique constraint: one membership per user per room
@@ -412,6 +653,16 @@ func ensureRoomMembersCollection(app core.App) error {
 	return app.Save(collection)
 }
 
+// roomPermissionsField is room_members.permissions: the grants a room owner has
+// handed to this member (see permissions.go).
+func roomPermissionsField() *core.SelectField {
+	return &core.SelectField{
+		Name:      "permissions",
+		Values:    roomPermissions,
+		MaxSelect: len(roomPermissions),
+	}
+}
+
 // ensureDirectMessagesCollection creates the direct_messages collection for 1:1 DMs.
 func ensureDirectMessagesCollection(app core.App) error {
 	existing, err := app.FindCollectionByNameOrId("direct_messages")
@@ -433,6 +684,10 @@ func ensureDirectMessagesCollection(app core.App) error {
 			})
 			changed = true
 		}
+		if existing.Fields.GetByName("disappear_after") == nil {
+			existing.Fields.Add(dmTimerField())
+			changed = true
+		}
 		if changed {
 			return app.Save(existing)
 		}
@@ -462,6 +717,8 @@ func ensureDirectMessagesCollection(app core.App) error {
 		MaxSelect:    1,
 	})
 
+	collection.Fields.Add(dmTimerField())
+
 	collection.Fields.Add(&core.AutodateField{
 		Name:     "created",
 		OnCreate: true,
@@ -484,7 +741,7 @@ func ensureDirectMessagesCollection(app core.App) error {
 func ensureDmMessagesCollection(app core.App) error {
 	existing, err := app.FindCollectionByNameOrId("dm_messages")
 	if err == nil {
-		// Collection exists — ensure autodate fields are present
+		// Collection exists — ensure autodate and reply fields are present
 		changed := false
 		if existing.Fields.GetByName("created") == nil {
 			existing.Fields.Add(&core.AutodateField{
@@ -501,6 +758,12 @@ func ensureDmMessagesCollection(app core.App) error {
 			})
 			changed = true
 		}
+		if addReplyFields(existing) {
+			changed = true
+		}
+		if addDmExpiryFields(existing) {
+			changed = true
+		}
 		if changed {
 			return app.Save(existing)
 		}
@@ -545,6 +808,8 @@ func ensureDmMessagesCollection(app core.App) error {
 		Max:      4000,
 	})
 
+	addDmExpiryFields(collection)
+
 	collection.Fields.Add(&core.AutodateField{
 		Name:     "created",
 		OnCreate: true,
@@ -556,102 +821,939 @@ func ensureDmMessagesCollection(app core.App) error {
 		OnUpdate: true,
 	})
 
+	if err := app.Save(collection); err != nil {
+		return err
+	}
+
+	// reply_to points back at this collection, so it can only be added once it exists
+	addReplyFields(collection)
 	return app.Save(collection)
 }
 
-// backfillSchemaDefaults sets default values on existing records that lack new fields.
-// This handles the v0.2.1 → v0.3 migration (ADR-007).
-func backfillSchemaDefaults(app core.App) error {
-	// Backfill rooms: existing rooms without a type → campfire
-	if _, err := app.DB().NewQuery(
-		`UPDATE rooms SET type = 'campfire' WHERE type = '' OR type IS NULL`,
-	).Execute(); err != nil {
-		return fmt.Errorf("backfill rooms.type: %w", err)
+// dmTimerField is direct_messages.disappear_after: the conversation's
+// disappearing-message timer in seconds (0 = off). See dm_timers.go.
+func dmTimerField() *core.NumberField {
+	return &core.NumberField{
+		Name:    "disappear_after",
+		OnlyInt: true,
+		Min:     floatPtr(0),
+		Max:     floatPtr(maxDmTimer),
 	}
+}
 
-	// Backfill rooms: set history_visible default for existing rooms
-	if _, err := app.DB().NewQuery(
-		`UPDATE rooms SET history_visible = 1 WHERE history_visible IS NULL`,
-	).Execute(); err != nil {
-		return fmt.Errorf("backfill rooms.history_visible: %w", err)
+// addDmExpiryFields adds the server-set type and expires_at to dm_messages.
+// Messages from before DM timers are backfilled as permanent in
+// backfillSchemaDefaults. Reports whether the fields were missing.
+func addDmExpiryFields(collection *core.Collection) bool {
+	if collection.Fields.GetByName("expires_at") != nil {
+		return false
 	}
 
-	// Backfill users: existing users without a role → member
-	if _, err := app.DB().NewQuery(
-		`UPDATE users SET role = 'member' WHERE role = '' OR role IS NULL`,
-	).Execute(); err != nil {
-		return fmt.Errorf("backfill users.role: %w", err)
-	}
+	// "system" is the notice posted when the timer changes
+	collection.Fields.Add(&core.SelectField{
+		Name:      "type",
+		Values:    []string{"text", "system"},
+		MaxSelect: 1,
+	})
 
-	// Crown the first user as homeowner (by creation timestamp)
-	if _, err := app.DB().NewQuery(
-		`UPDATE users SET role = 'homeowner' WHERE id = (SELECT id FROM users ORDER BY created ASC LIMIT 1) AND role != 'homeowner'`,
-	).Execute(); err != nil {
-		// Don't fail startup if there are no users yet
-		app.Logger().Warn("homeowner backfill skipped (maybe no users yet)", "error", err)
-	}
+	collection.Fields.Add(&core.DateField{
+		Name: "expires_at",
+	})
 
-	return nil
+	return true
 }
 
-// applyAPIRules sets API rules on all Hearth collections.
-// This runs AFTER all collections are created, so back-relation rules
-// (e.g., rooms referencing room_members_via_room) can be validated.
-func applyAPIRules(app core.App) error {
-	// Users rules — allow authenticated users to search for other users (for DMs)
-	users, err := app.FindCollectionByNameOrId("users")
-	if err != nil {
-		return fmt.Errorf("users not found for rules: %w", err)
-	}
-	users.ListRule = stringPtr(`@request.auth.id != ""`)
-	users.ViewRule = stringPtr(`@request.auth.id != ""`)
-	if err := app.Save(users); err != nil {
-		return fmt.Errorf("users rules: %w", err)
+// ensureKnocksCollection creates the knocks collection for The Knock (guest entry).
+// Knocks are written only by the /api/hearth/knock endpoints — never directly by clients.
+func ensureKnocksCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("knocks")
+	if err == nil {
+		return nil
 	}
 
-	// Rooms rules
-	rooms, err := app.FindCollectionByNameOrId("rooms")
+	roomsCol, err := app.FindCollectionByNameOrId("rooms")
 	if err != nil {
-		return fmt.Errorf("rooms not found for rules: %w", err)
+		return fmt.Errorf("rooms collection not found: %w", err)
 	}
-	// ADR-006: Any authenticated user can list/view rooms (open-lobby model)
-	rooms.ListRule = stringPtr(`@request.auth.id != ""`)
-	rooms.ViewRule = stringPtr(`@request.auth.id != ""`)
-	rooms.CreateRule = stringPtr(`@request.auth.id != ""`)
-	rooms.UpdateRule = stringPtr(`@request.auth.id = owner`)
-	rooms.DeleteRule = stringPtr(`@request.auth.id = owner`)
-	if err := app.Save(rooms); err != nil {
-		return fmt.Errorf("rooms rules: %w", err)
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
 	}
 
-	// Messages rules
-	messages, err := app.FindCollectionByNameOrId("messages")
-	if err != nil {
-		return fmt.Errorf("messages not found for rules: %w", err)
+	collection := core.NewBaseCollection("knocks")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "room",
+		Required:      true,
+		CollectionId:  roomsCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	// The account knocking, if any (set when the guest is already signed in)
+	collection.Fields.Add(&core.RelationField{
+		Name:          "user",
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name:     "display_name",
+		Required: true,
+		Min:      1,
+		Max:      50,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name: "note",
+		Max:  280,
+	})
+
+	collection.Fields.Add(&core.SelectField{
+		Name:      "status",
+		Required:  true,
+		Values:    []string{knockPending, knockApproved, knockDenied},
+		MaxSelect: 1,
+	})
+
+	// Membership role the invite grants on approval (signed into v2 invites)
+	collection.Fields.Add(&core.SelectField{
+		Name:      "grant_role",
+		Values:    []string{inviteRoleGuest, inviteRoleMember},
+		MaxSelect: 1,
+	})
+
+	// Poll secret handed to the guest on creation — never exposed via the records API
+	collection.Fields.Add(&core.TextField{
+		Name:   "secret",
+		Hidden: true,
+		Max:    100,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:         "answered_by",
+		CollectionId: usersCol.Id,
+		MaxSelect:    1,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "updated",
+		OnCreate: true,
+		OnUpdate: true,
+	})
+
+	collection.Indexes = []string{
+		"CREATE INDEX idx_knocks_room_status ON knocks (room, status)",
+		"CREATE INDEX idx_knocks_created ON knocks (created)",
 	}
-	// ADR-006: Messages require room membership (defense-in-depth)
-	messages.ListRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= room.room_members_via_room.user`)
-	messages.ViewRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= room.room_members_via_room.user`)
-	messages.CreateRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= room.room_members_via_room.user`)
-	messages.UpdateRule = stringPtr(`@request.auth.id = author`)
-	messages.DeleteRule = stringPtr(`@request.auth.id = author || @request.auth.id = room.owner`)
-	if err := app.Save(messages); err != nil {
-		return fmt.Errorf("messages rules: %w", err)
+
+	return app.Save(collection)
+}
+
+// ensureInvitesCollection creates the optional invites collection backing "tracked"
+// invite links (max uses, use counter, revocation). Stateless links never touch it.
+func ensureInvitesCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("invites")
+	if err == nil {
+		return nil
 	}
 
-	// Room members rules
-	members, err := app.FindCollectionByNameOrId("room_members")
+	roomsCol, err := app.FindCollectionByNameOrId("rooms")
 	if err != nil {
-		return fmt.Errorf("room_members not found for rules: %w", err)
+		return fmt.Errorf("rooms collection not found: %w", err)
 	}
-	members.ListRule = stringPtr(`@request.auth.id != ""`)
-	members.ViewRule = stringPtr(`@request.auth.id != ""`)
-	// ADR-006: Any auth user can create membership (self-join)
-	members.CreateRule = stringPtr(`@request.auth.id != ""`)
-	members.UpdateRule = stringPtr(`@request.auth.id = room.owner`)
-	members.DeleteRule = stringPtr(`@request.auth.id = room.owner || @request.auth.id = user`)
-	if err := app.Save(members); err != nil {
-		return fmt.Errorf("room_members rules: %w", err)
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("invites")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "room",
+		Required:      true,
+		CollectionId:  roomsCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "created_by",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.NumberField{
+		Name:    "max_uses",
+		Min:     floatPtr(0), // 0 = unlimited
+		OnlyInt: true,
+	})
+
+	collection.Fields.Add(&core.NumberField{
+		Name:    "uses",
+		Min:     floatPtr(0),
+		OnlyInt: true,
+	})
+
+	collection.Fields.Add(&core.BoolField{
+		Name: "revoked",
+	})
+
+	collection.Fields.Add(&core.DateField{
+		Name:     "expires_at",
+		Required: true,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "updated",
+		OnCreate: true,
+		OnUpdate: true,
+	})
+
+	collection.Indexes = []string{
+		"CREATE INDEX idx_invites_room ON invites (room)",
+	}
+
+	return app.Save(collection)
+}
+
+// ensureInviteCodesCollection creates the invite_codes collection: short, typeable
+// codes that resolve to a signed invite token. Written and read only by the invite
+// endpoints — the token is the invite, so there are no client-facing API rules.
+func ensureInviteCodesCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("invite_codes")
+	if err == nil {
+		return nil
+	}
+
+	roomsCol, err := app.FindCollectionByNameOrId("rooms")
+	if err != nil {
+		return fmt.Errorf("rooms collection not found: %w", err)
+	}
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("invite_codes")
+
+	collection.Fields.Add(&core.TextField{
+		Name:     "code",
+		Required: true,
+		Min:      inviteCodeLength,
+		Max:      inviteCodeLength,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name:     "token",
+		Required: true,
+		Hidden:   true,
+		Max:      2000,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "room",
+		Required:      true,
+		CollectionId:  roomsCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "created_by",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.DateField{
+		Name:     "expires_at",
+		Required: true,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	collection.Indexes = []string{
+		"CREATE UNIQUE INDEX idx_invite_codes_code ON invite_codes (code)",
+		"CREATE INDEX idx_invite_codes_expires ON invite_codes (expires_at)",
+	}
+
+	return app.Save(collection)
+}
+
+// ensureHouseSettingsCollection creates the single-record house_settings collection
+// (House-wide policy the Homeowner controls) and seeds its one record.
+func ensureHouseSettingsCollection(app core.App) error {
+	collection, err := app.FindCollectionByNameOrId("house_settings")
+	if err != nil {
+		collection = core.NewBaseCollection("house_settings")
+
+		// Whether Members may create campfires (Homeowners and Keyholders always can)
+		collection.Fields.Add(&core.BoolField{
+			Name: "members_create_campfires",
+		})
+
+		collection.Fields.Add(externalAvatarsField())
+
+		addTTLPolicyFields(collection)
+
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "updated",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+	} else {
+		if collection.Fields.GetByName("external_avatars") == nil {
+			collection.Fields.Add(externalAvatarsField())
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+			// Houses that already had avatar URLs keep showing them until the Homeowner says otherwise
+			if _, err := app.DB().NewQuery(`UPDATE house_settings SET external_avatars = 1`).Execute(); err != nil {
+				return err
+			}
+		}
+
+		if collection.Fields.GetByName("campfire_ttl_default") == nil {
+			addTTLPolicyFields(collection)
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+			// Existing Houses start with the bounds the rooms schema used to enforce
+			if _, err := app.DB().NewQuery(
+				`UPDATE house_settings SET campfire_ttl_min = {:min}, campfire_ttl_max = {:max}, campfire_ttl_default = {:default}`,
+			).Bind(dbx.Params{
+				"min":     defaultTTLPolicy.CampfireMin,
+				"max":     defaultTTLPolicy.CampfireMax,
+				"default": defaultTTLPolicy.CampfireDefault,
+			}).Execute(); err != nil {
+				return err
+			}
+		}
+	}
+
+	total, err := app.CountRecords(collection)
+	if err != nil || total > 0 {
+		return err
+	}
+
+	// Seed with today's behaviour: anyone can light a campfire. New Houses
+	// start with uploaded avatars only.
+	settings := core.NewRecord(collection)
+	settings.Set("members_create_campfires", true)
+	settings.Set("external_avatars", false)
+	settings.Set("campfire_ttl_min", defaultTTLPolicy.CampfireMin)
+	settings.Set("campfire_ttl_max", defaultTTLPolicy.CampfireMax)
+	settings.Set("campfire_ttl_default", defaultTTLPolicy.CampfireDefault)
+	settings.Set("dens_may_expire", defaultTTLPolicy.DensMayExpire)
+	return app.Save(settings)
+}
+
+// addTTLPolicyFields adds the Homeowner's message TTL bounds to house_settings
+// (see ttl_policy.go). All in seconds; the rooms schema's 0–86400 still applies.
+func addTTLPolicyFields(collection *core.Collection) {
+	for _, name := range []string{"campfire_ttl_min", "campfire_ttl_max", "campfire_ttl_default"} {
+		collection.Fields.Add(&core.NumberField{
+			Name:    name,
+			OnlyInt: true,
+			Min:     floatPtr(minRoomTTL),
+			Max:     floatPtr(86400),
+		})
+	}
+
+	// Whether dens may fade their messages too (otherwise den default_ttl is 0)
+	collection.Fields.Add(&core.BoolField{
+		Name: "dens_may_expire",
+	})
+}
+
+// externalAvatarsField is house_settings.external_avatars: whether users.avatar_url
+// (a hotlinked image) is accepted and shown at all.
+func externalAvatarsField() *core.BoolField {
+	return &core.BoolField{
+		Name: "external_avatars",
+	}
+}
+
+// ensureAuditLogCollection creates the append-only audit_log collection (role changes,
+// Homeowner crowning and transfers). Entries outlive the accounts they mention.
+func ensureAuditLogCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("audit_log")
+	if err == nil {
+		return nil
+	}
+
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("audit_log")
+
+	collection.Fields.Add(&core.TextField{
+		Name:     "action",
+		Required: true,
+		Max:      50,
+	})
+
+	// Who did it — empty for system actions (first-user crowning, backfill)
+	collection.Fields.Add(&core.RelationField{
+		Name:         "actor",
+		CollectionId: usersCol.Id,
+		MaxSelect:    1,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:         "target",
+		CollectionId: usersCol.Id,
+		MaxSelect:    1,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name: "target_name",
+		Max:  50,
+	})
+
+	collection.Fields.Add(&core.JSONField{
+		Name:    "details",
+		MaxSize: 2000,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	collection.Indexes = []string{
+		"CREATE INDEX idx_audit_log_action ON audit_log (action)",
+		"CREATE INDEX idx_audit_log_created ON audit_log (created)",
+	}
+
+	return app.Save(collection)
+}
+
+// ensureHomeownerTransfersCollection creates the homeowner_transfers collection:
+// pending offers of Homeowner status, accepted by the recipient.
+func ensureHomeownerTransfersCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("homeowner_transfers")
+	if err == nil {
+		return nil
+	}
+
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("homeowner_transfers")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "from",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "to",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.SelectField{
+		Name:      "status",
+		Required:  true,
+		Values:    []string{transferPending, transferAccepted, transferCancelled},
+		MaxSelect: 1,
+	})
+
+	collection.Fields.Add(&core.DateField{
+		Name:     "expires_at",
+		Required: true,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "updated",
+		OnCreate: true,
+		OnUpdate: true,
+	})
+
+	return app.Save(collection)
+}
+
+// ensureMessageReactionsCollection creates the message_reactions collection: one row
+// per (message, user, emoji). Reactions cascade with their message and their user.
+func ensureMessageReactionsCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("message_reactions")
+	if err == nil {
+		return nil
+	}
+
+	messagesCol, err := app.FindCollectionByNameOrId("messages")
+	if err != nil {
+		return fmt.Errorf("messages collection not found: %w", err)
+	}
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("message_reactions")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "message",
+		Required:      true,
+		CollectionId:  messagesCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "user",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	// An emoji (possibly several code points, e.g. skin tones or ZWJ sequences)
+	collection.Fields.Add(&core.TextField{
+		Name:     "emoji",
+		Required: true,
+		Max:      32,
+		Pattern:  `^\S+$`,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	// Rules applied in pass 2 via applyAPIRules
+
+	// One reaction per user per emoji per message (also serves lookups by message)
+	collection.Indexes = []string{
+		"CREATE UNIQUE INDEX idx_message_reactions_unique ON message_reactions (message, \"user\", emoji)",
+	}
+
+	return app.Save(collection)
+}
+
+// ensureMessageRevisionsCollection creates the message_revisions collection: the
+// body a message had before each edit. Revisions cascade with their message.
+func ensureMessageRevisionsCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("message_revisions")
+	if err == nil {
+		return nil
+	}
+
+	messagesCol, err := app.FindCollectionByNameOrId("messages")
+	if err != nil {
+		return fmt.Errorf("messages collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("message_revisions")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "message",
+		Required:      true,
+		CollectionId:  messagesCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.TextField{
+		Name:     "body",
+		Required: true,
+		Max:      4000,
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	// Rules applied in pass 2 via applyAPIRules
+
+	collection.Indexes = []string{
+		"CREATE INDEX idx_message_revisions_message ON message_revisions (message)",
+	}
+
+	return app.Save(collection)
+}
+
+// ensureAttachmentsCollection creates the attachments collection: one uploaded file
+// per row, hung off a message. Attachments cascade with their message and their
+// uploader; size and dimensions are filled in by the server (see attachments.go).
+func ensureAttachmentsCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("attachments")
+	if err == nil {
+		return nil
+	}
+
+	messagesCol, err := app.FindCollectionByNameOrId("messages")
+	if err != nil {
+		return fmt.Errorf("messages collection not found: %w", err)
+	}
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("attachments")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "message",
+		Required:      true,
+		CollectionId:  messagesCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "uploader",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	// Protected: downloads need a file token and pass the view rule, so a
+	// campfire photo can't be passed around by URL
+	collection.Fields.Add(&core.FileField{
+		Name:      "file",
+		Required:  true,
+		MaxSelect: 1,
+		MaxSize:   maxAttachmentSize,
+		MimeTypes: attachmentMimeTypes,
+		Thumbs:    attachmentThumbs,
+		Protected: true,
+	})
+
+	collection.Fields.Add(&core.NumberField{
+		Name:    "size",
+		OnlyInt: true,
+		Min:     floatPtr(0),
+	})
+
+	collection.Fields.Add(&core.NumberField{
+		Name:    "width",
+		OnlyInt: true,
+		Min:     floatPtr(0),
+	})
+
+	collection.Fields.Add(&core.NumberField{
+		Name:    "height",
+		OnlyInt: true,
+		Min:     floatPtr(0),
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	// Rules applied in pass 2 via applyAPIRules
+
+	collection.Indexes = []string{
+		"CREATE INDEX idx_attachments_message ON attachments (message)",
+		"CREATE INDEX idx_attachments_uploader ON attachments (uploader)",
+	}
+
+	return app.Save(collection)
+}
+
+// ensureUserBlocksCollection creates the user_blocks collection: one row per user
+// someone has blocked (see blocks.go). Only the blocker ever sees it.
+func ensureUserBlocksCollection(app core.App) error {
+	_, err := app.FindCollectionByNameOrId("user_blocks")
+	if err == nil {
+		return nil
+	}
+
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users collection not found: %w", err)
+	}
+
+	collection := core.NewBaseCollection("user_blocks")
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "blocker",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	collection.Fields.Add(&core.RelationField{
+		Name:          "blocked",
+		Required:      true,
+		CollectionId:  usersCol.Id,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	})
+
+	// The blocker opts out of voice calls with the blocked user too
+	collection.Fields.Add(&core.BoolField{
+		Name: "no_shared_voice",
+	})
+
+	collection.Fields.Add(&core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+	})
+
+	// Rules applied in pass 2 via applyAPIRules
+
+	// One block per pair (also serves lookups by blocker); lookups by blocked
+	// answer "who has blocked me" for presence and voice
+	collection.Indexes = []string{
+		"CREATE UNIQUE INDEX idx_user_blocks_unique ON user_blocks (blocker, blocked)",
+		"CREATE INDEX idx_user_blocks_blocked ON user_blocks (blocked)",
+	}
+
+	return app.Save(collection)
+}
+
+// backfillSchemaDefaults sets default values on existing records that lack new fields.
+// This handles the v0.2.1 → v0.3 migration (ADR-007).
+func backfillSchemaDefaults(app core.App) error {
+	// Backfill rooms: existing rooms without a type → campfire
+	if _, err := app.DB().NewQuery(
+		`UPDATE rooms SET type = 'campfire' WHERE type = '' OR type IS NULL`,
+	).Execute(); err != nil {
+		return fmt.Errorf("backfill rooms.type: %w", err)
+	}
+
+	// Backfill rooms: rooms from the open-lobby days stay open
+	if _, err := app.DB().NewQuery(
+		`UPDATE rooms SET visibility = 'open' WHERE visibility = '' OR visibility IS NULL`,
+	).Execute(); err != nil {
+		return fmt.Errorf("backfill rooms.visibility: %w", err)
+	}
+
+	// Backfill rooms: rooms from before campfire extinction start their idle clock now
+	if _, err := app.DB().NewQuery(
+		`UPDATE rooms SET last_activity = {:now} WHERE last_activity = '' OR last_activity IS NULL`,
+	).Bind(dbxParams("now", types.NowDateTime().String())).Execute(); err != nil {
+		return fmt.Errorf("backfill rooms.last_activity: %w", err)
+	}
+
+	// Backfill rooms: set history_visible default for existing rooms
+	if _, err := app.DB().NewQuery(
+		`UPDATE rooms SET history_visible = 1 WHERE history_visible IS NULL`,
+	).Execute(); err != nil {
+		return fmt.Errorf("backfill rooms.history_visible: %w", err)
+	}
+
+	// Backfill dm_messages: DMs from before disappearing timers are permanent
+	never, err := types.ParseDateTime(permanentExpiry)
+	if err != nil {
+		return err
+	}
+	if _, err := app.DB().NewQuery(
+		`UPDATE dm_messages SET expires_at = {:never}, type = 'text' WHERE expires_at = '' OR expires_at IS NULL`,
+	).Bind(dbxParams("never", never.String())).Execute(); err != nil {
+		return fmt.Errorf("backfill dm_messages.expires_at: %w", err)
+	}
+
+	// Backfill users: existing users without a role → member
+	if _, err := app.DB().NewQuery(
+		`UPDATE users SET role = 'member' WHERE role = '' OR role IS NULL`,
+	).Execute(); err != nil {
+		return fmt.Errorf("backfill users.role: %w", err)
+	}
+
+	// Crown the first user as homeowner (by creation timestamp) — once per House,
+	// so a transferred or deleted Homeowner is never replaced behind anyone's back
+	if err := backfillHomeowner(app); err != nil {
+		// Don't fail startup if there are no users yet
+		app.Logger().Warn("homeowner backfill skipped (maybe no users yet)", "error", err)
+	}
+
+	return nil
+}
+
+// applyAPIRules sets API rules on all Hearth collections.
+// This runs AFTER all collections are created, so back-relation rules
+// (e.g., rooms referencing room_members_via_room) can be validated.
+func applyAPIRules(app core.App) error {
+	// Users rules — allow authenticated users to search for other users (for DMs)
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return fmt.Errorf("users not found for rules: %w", err)
+	}
+	users.ListRule = stringPtr(`@request.auth.id != ""`)
+	users.ViewRule = stringPtr(`@request.auth.id != ""`)
+	if err := app.Save(users); err != nil {
+		return fmt.Errorf("users rules: %w", err)
+	}
+
+	// Rooms rules
+	rooms, err := app.FindCollectionByNameOrId("rooms")
+	if err != nil {
+		return fmt.Errorf("rooms not found for rules: %w", err)
+	}
+	// ADR-006: Any authenticated user can list/view rooms (open-lobby model) —
+	// except private rooms, which only their members and moderators can see.
+	rooms.ListRule = stringPtr(`@request.auth.id != "" && (` + roomVisibleRule + `)`)
+	rooms.ViewRule = stringPtr(`@request.auth.id != "" && (` + roomVisibleRule + `)`)
+	// ADR-007 roles: Homeowners and Keyholders create any room; Members create
+	// campfires if the House allows it. Guests are scoped to the room they were
+	// let into — no room creation. Only the Homeowner creates rooms for others.
+	rooms.CreateRule = stringPtr(`@request.auth.id != "" && @request.auth.guest != true && ` +
+		`(@request.body.owner = @request.auth.id || @request.auth.role = "homeowner") && ` +
+		`(@request.auth.role = "homeowner" || @request.auth.role = "keyholder" || ` +
+		`(@request.body.type != "den" && @collection.house_settings.members_create_campfires ?= true))`)
+	// Owners configure their room, Keyholders configure dens and delegated rooms,
+	// members holding the configure grant their room, the Homeowner anything. Only the owner (or Homeowner) hands the room over or
+	// changes its delegation, and only Keyholders+ can turn a campfire into a den.
+	rooms.UpdateRule = stringPtr(`@request.auth.role = "homeowner" || (` +
+		`(owner = @request.auth.id || (@request.auth.role = "keyholder" && (type = "den" || keyholders.id ?= @request.auth.id)) || ` +
+		roomGrantRule("", permConfigure) + `) && ` +
+		`(owner = @request.auth.id || (@request.body.owner:isset = false && @request.body.keyholders:isset = false)) && ` +
+		`(@request.body.type:isset = false || @request.body.type = type || @request.auth.role = "keyholder"))`)
+	rooms.DeleteRule = stringPtr(`@request.auth.id = owner || @request.auth.role = "homeowner"`)
+	if err := app.Save(rooms); err != nil {
+		return fmt.Errorf("rooms rules: %w", err)
+	}
+
+	// Messages rules
+	messages, err := app.FindCollectionByNameOrId("messages")
+	if err != nil {
+		return fmt.Errorf("messages not found for rules: %w", err)
+	}
+	// ADR-006: Messages require room membership (defense-in-depth)
+	messages.ListRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= room.room_members_via_room.user`)
+	messages.ViewRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= room.room_members_via_room.user`)
+	messages.CreateRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= room.room_members_via_room.user`)
+	messages.UpdateRule = stringPtr(`@request.auth.id = author`)
+	messages.DeleteRule = stringPtr(`@request.auth.id = author || @request.auth.id = room.owner || ` + roomModeratorRule + ` || ` +
+		roomGrantRule("room.", permModerateMessages))
+	if err := app.Save(messages); err != nil {
+		return fmt.Errorf("messages rules: %w", err)
+	}
+
+	// Message reactions rules — same membership check as messages; everyone
+	// reacts as themselves and takes back only their own reactions.
+	reactions, err := app.FindCollectionByNameOrId("message_reactions")
+	if err != nil {
+		return fmt.Errorf("message_reactions not found for rules: %w", err)
+	}
+	reactions.ListRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= message.room.room_members_via_room.user`)
+	reactions.ViewRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= message.room.room_members_via_room.user`)
+	reactions.CreateRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= message.room.room_members_via_room.user && ` +
+		`@request.body.user = @request.auth.id`)
+	reactions.UpdateRule = nil // react again instead
+	reactions.DeleteRule = stringPtr(`@request.auth.id = user`)
+	if err := app.Save(reactions); err != nil {
+		return fmt.Errorf("message_reactions rules: %w", err)
+	}
+
+	// Message revisions rules — prior versions are for whoever moderates the
+	// room, not the room at large. Written only by the server (see edits.go).
+	revisions, err := app.FindCollectionByNameOrId("message_revisions")
+	if err != nil {
+		return fmt.Errorf("message_revisions not found for rules: %w", err)
+	}
+	revisionsRule := `@request.auth.id = message.room.owner || ` + roomModeratorRuleAt("message.room.") + ` || ` +
+		roomGrantRule("message.room.", permModerateMessages)
+	revisions.ListRule = stringPtr(revisionsRule)
+	revisions.ViewRule = stringPtr(revisionsRule)
+	revisions.CreateRule = nil
+	revisions.UpdateRule = nil
+	revisions.DeleteRule = nil
+	if err := app.Save(revisions); err != nil {
+		return fmt.Errorf("message_revisions rules: %w", err)
+	}
+
+	// Attachments rules — same membership check as messages; files go up only
+	// on your own messages and come down only by their uploader (or with the message).
+	attachments, err := app.FindCollectionByNameOrId("attachments")
+	if err != nil {
+		return fmt.Errorf("attachments not found for rules: %w", err)
+	}
+	attachments.ListRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= message.room.room_members_via_room.user`)
+	attachments.ViewRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= message.room.room_members_via_room.user`)
+	attachments.CreateRule = stringPtr(`@request.auth.id != "" && @request.auth.id ?= message.room.room_members_via_room.user && ` +
+		`@request.body.uploader = @request.auth.id && message.author = @request.auth.id`)
+	attachments.UpdateRule = nil // upload again instead
+	attachments.DeleteRule = stringPtr(`@request.auth.id = uploader`)
+	if err := app.Save(attachments); err != nil {
+		return fmt.Errorf("attachments rules: %w", err)
+	}
+
+	// User blocks rules — a block is the blocker's alone; the blocked user never sees it
+	blocks, err := app.FindCollectionByNameOrId("user_blocks")
+	if err != nil {
+		return fmt.Errorf("user_blocks not found for rules: %w", err)
+	}
+	blocks.ListRule = stringPtr(`@request.auth.id != "" && blocker = @request.auth.id`)
+	blocks.ViewRule = stringPtr(`@request.auth.id != "" && blocker = @request.auth.id`)
+	blocks.CreateRule = stringPtr(`@request.auth.id != "" && @request.body.blocker = @request.auth.id && ` +
+		`@request.body.blocked != @request.auth.id`)
+	blocks.UpdateRule = stringPtr(`@request.auth.id != "" && blocker = @request.auth.id && ` +
+		`@request.body.blocker:isset = false && @request.body.blocked:isset = false`)
+	blocks.DeleteRule = stringPtr(`@request.auth.id != "" && blocker = @request.auth.id`)
+	if err := app.Save(blocks); err != nil {
+		return fmt.Errorf("user_blocks rules: %w", err)
+	}
+
+	// Room members rules
+	members, err := app.FindCollectionByNameOrId("room_members")
+	if err != nil {
+		return fmt.Errorf("room_members not found for rules: %w", err)
+	}
+	// Who's in a private room is as private as the room itself
+	members.ListRule = stringPtr(`@request.auth.id != "" && (` + roomVisibleRuleAt("room.") + `)`)
+	members.ViewRule = stringPtr(`@request.auth.id != "" && (` + roomVisibleRuleAt("room.") + `)`)
+	// ADR-006: Any auth user can join an open room as a member — except guests.
+	// Listed-but-knock and private rooms are entered by invite and Knock only, or
+	// added by the owner, a room moderator or a manage_members grant holder.
+	members.CreateRule = stringPtr(`@request.auth.id != "" && @request.auth.guest != true && (` +
+		`(@request.body.user = @request.auth.id && @request.body.role = "member" && room.visibility = "open" && ` +
+		`@request.body.permissions:isset = false && @request.body.vouched_by:isset = false) || ` +
+		`@request.auth.id = room.owner || ` + roomModeratorRule + ` || (` +
+		roomGrantRule("room.", permManageMembers) + ` && @request.body.role != "owner" && @request.body.permissions:isset = false))`)
+	// Members holding manage_members can change and remove memberships, but not
+	// touch the owner's, crown a new owner, or hand out grants.
+	members.UpdateRule = stringPtr(`@request.auth.id = room.owner || ` + roomModeratorRule + ` || (` +
+		roomGrantRule("room.", permManageMembers) + ` && role != "owner" && @request.body.permissions:isset = false && ` +
+		`(@request.body.role:isset = false || @request.body.role != "owner"))`)
+	members.DeleteRule = stringPtr(`@request.auth.id = room.owner || @request.auth.id = user || ` + roomModeratorRule + ` || (` +
+		roomGrantRule("room.", permManageMembers) + ` && role != "owner")`)
+	if err := app.Save(members); err != nil {
+		return fmt.Errorf("room_members rules: %w", err)
 	}
 
 	// Direct messages rules — only participants can see their DMs
@@ -661,8 +1763,9 @@ func applyAPIRules(app core.App) error {
 	}
 	dms.ListRule = stringPtr(`participant_a = @request.auth.id || participant_b = @request.auth.id`)
 	dms.ViewRule = stringPtr(`participant_a = @request.auth.id || participant_b = @request.auth.id`)
-	dms.CreateRule = stringPtr(`@request.auth.id != ""`)
-	dms.UpdateRule = nil // DMs cannot be updated
+	dms.CreateRule = nil // opened through /api/hearth/dm/open, which keeps pairs canonical
+	// Participants may only set the disappearing timer (dm_timers.go resets anything else)
+	dms.UpdateRule = stringPtr(`participant_a = @request.auth.id || participant_b = @request.auth.id`)
 	dms.DeleteRule = nil // DMs cannot be deleted (permanent)
 	if err := app.Save(dms); err != nil {
 		return fmt.Errorf("direct_messages rules: %w", err)
@@ -675,16 +1778,125 @@ func applyAPIRules(app core.App) error {
 	}
 	dmMsgs.ListRule = stringPtr(`dm.participant_a = @request.auth.id || dm.participant_b = @request.auth.id`)
 	dmMsgs.ViewRule = stringPtr(`dm.participant_a = @request.auth.id || dm.participant_b = @request.auth.id`)
-	dmMsgs.CreateRule = stringPtr(`dm.participant_a = @request.auth.id || dm.participant_b = @request.auth.id`)
-	dmMsgs.UpdateRule = stringPtr(`author = @request.auth.id`)
+	dmMsgs.CreateRule = stringPtr(`(dm.participant_a = @request.auth.id || dm.participant_b = @request.auth.id) && ` +
+		`(@request.body.type:isset = false || @request.body.type = "text")`)
+	// Timer notices stay as the server wrote them; expiry and the quoted parent are
+	// never the author's to change
+	dmMsgs.UpdateRule = stringPtr(`author = @request.auth.id && type != "system" && ` +
+		`@request.body.type:isset = false && @request.body.expires_at:isset = false && ` +
+		`@request.body.reply_to:isset = false && @request.body.reply_author_name:isset = false && ` +
+		`@request.body.reply_excerpt:isset = false`)
 	dmMsgs.DeleteRule = stringPtr(`author = @request.auth.id`)
 	if err := app.Save(dmMsgs); err != nil {
 		return fmt.Errorf("dm_messages rules: %w", err)
 	}
 
+	// Knocks rules — room moderators see the doorstep (list/view also gates
+	// realtime subscriptions). Writes go through /api/hearth/knock.
+	knocks, err := app.FindCollectionByNameOrId("knocks")
+	if err != nil {
+		return fmt.Errorf("knocks not found for rules: %w", err)
+	}
+	knocks.ListRule = stringPtr(`@request.auth.id != "" && (room.owner = @request.auth.id || ` + roomModeratorRule + ` || ` +
+		roomGrantRule("room.", permManageMembers) + `)`)
+	knocks.ViewRule = stringPtr(`@request.auth.id != "" && (room.owner = @request.auth.id || ` + roomModeratorRule + ` || ` +
+		roomGrantRule("room.", permManageMembers) + `)`)
+	knocks.CreateRule = nil
+	knocks.UpdateRule = nil
+	knocks.DeleteRule = nil
+	if err := app.Save(knocks); err != nil {
+		return fmt.Errorf("knocks rules: %w", err)
+	}
+
+	// Invites rules — creators and room owners can read tracked invites.
+	// Writes go through /api/hearth/invite (generate, revoke).
+	invites, err := app.FindCollectionByNameOrId("invites")
+	if err != nil {
+		return fmt.Errorf("invites not found for rules: %w", err)
+	}
+	invites.ListRule = stringPtr(`created_by = @request.auth.id || room.owner = @request.auth.id || @request.auth.role = "homeowner"`)
+	invites.ViewRule = stringPtr(`created_by = @request.auth.id || room.owner = @request.auth.id || @request.auth.role = "homeowner"`)
+	invites.CreateRule = nil
+	invites.UpdateRule = nil
+	invites.DeleteRule = nil
+	if err := app.Save(invites); err != nil {
+		return fmt.Errorf("invites rules: %w", err)
+	}
+
+	// House settings rules — everyone signed in can read them (the UI needs to
+	// know what to offer); only the Homeowner changes them.
+	house, err := app.FindCollectionByNameOrId("house_settings")
+	if err != nil {
+		return fmt.Errorf("house_settings not found for rules: %w", err)
+	}
+	house.ListRule = stringPtr(`@request.auth.id != ""`)
+	house.ViewRule = stringPtr(`@request.auth.id != ""`)
+	house.CreateRule = nil
+	house.UpdateRule = stringPtr(`@request.auth.role = "homeowner"`)
+	house.DeleteRule = nil
+	if err := app.Save(house); err != nil {
+		return fmt.Errorf("house_settings rules: %w", err)
+	}
+
+	// Audit log rules — the Homeowner reads it; nobody writes it through the API.
+	audit, err := app.FindCollectionByNameOrId("audit_log")
+	if err != nil {
+		return fmt.Errorf("audit_log not found for rules: %w", err)
+	}
+	audit.ListRule = stringPtr(`@request.auth.role = "homeowner"`)
+	audit.ViewRule = stringPtr(`@request.auth.role = "homeowner"`)
+	audit.CreateRule = nil
+	audit.UpdateRule = nil
+	audit.DeleteRule = nil
+	if err := app.Save(audit); err != nil {
+		return fmt.Errorf("audit_log rules: %w", err)
+	}
+
+	// Homeowner transfer rules — both sides can see the offer (so the recipient
+	// can find it). Writes go through /api/hearth/admin/transfer.
+	transfers, err := app.FindCollectionByNameOrId("homeowner_transfers")
+	if err != nil {
+		return fmt.Errorf("homeowner_transfers not found for rules: %w", err)
+	}
+	transfers.ListRule = stringPtr(`from = @request.auth.id || to = @request.auth.id`)
+	transfers.ViewRule = stringPtr(`from = @request.auth.id || to = @request.auth.id`)
+	transfers.CreateRule = nil
+	transfers.UpdateRule = nil
+	transfers.DeleteRule = nil
+	if err := app.Save(transfers); err != nil {
+		return fmt.Errorf("homeowner_transfers rules: %w", err)
+	}
+
 	return nil
 }
 
+// roomModeratorRule matches the Homeowner or a Keyholder the record's room is
+// delegated to (the rule form of canModerateRoom, minus the owner check).
+var roomModeratorRule = roomModeratorRuleAt("room.")
+
+// roomModeratorRuleAt is roomModeratorRule for a room reached through roomPath
+// (e.g. "message.room.").
+func roomModeratorRuleAt(roomPath string) string {
+	return `@request.auth.role = "homeowner" || (@request.auth.role = "keyholder" && ` + roomPath + `keyholders.id ?= @request.auth.id)`
+}
+
+// Room visibility (rooms.visibility).
+const (
+	roomVisibilityOpen    = "open"             // listed; anyone signed in can walk in
+	roomVisibilityKnock   = "listed-but-knock" // listed; getting in takes an invite and a Knock
+	roomVisibilityPrivate = "private"          // unlisted; only members and moderators see it
+)
+
+// roomVisibleRule matches rooms the requester may see: anything not private, or
+// a private room they belong to or moderate.
+var roomVisibleRule = roomVisibleRuleAt("")
+
+// roomVisibleRuleAt is roomVisibleRule for the room at roomPath (e.g. "room.").
+func roomVisibleRuleAt(roomPath string) string {
+	return roomPath + `visibility != "private" || ` + roomPath + `owner = @request.auth.id || ` +
+		roomPath + `room_members_via_room.user ?= @request.auth.id || ` + roomModeratorRuleAt(roomPath)
+}
+
 // createIndexes adds performance-critical indexes for the message GC query.
 func createIndexes(app core.App) error {
 	_, err := app.DB().NewQuery(`
@@ -692,6 +1904,15 @@ func createIndexes(app core.App) error {
 		ON messages (expires_at) 
 		WHERE expires_at IS NOT NULL
 	`).Execute()
+	if err != nil {
+		return err
+	}
+
+	_, err = app.DB().NewQuery(`
+		CREATE INDEX IF NOT EXISTS idx_dm_messages_expires_at
+		ON dm_messages (expires_at)
+		WHERE expires_at IS NOT NULL
+	`).Execute()
 	return err
 }
 
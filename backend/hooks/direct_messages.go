@@ -0,0 +1,106 @@
+package hooks
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterDirectMessages sets up /api/hearth/dm/open, the only way to start a DM.
+// direct_messages holds one row per pair of users with participant_a the
+// lower-sorted id, so the unique index on (participant_a, participant_b) is what
+// stops a pair from having two conversations. Clients can't create rows directly.
+func RegisterDirectMessages(app *pocketbase.PocketBase) {
+	app.OnServe().BindFunc(dmRoutes)
+}
+
+// dmRoutes registers the DM endpoints.
+func dmRoutes(se *core.ServeEvent) error {
+	// POST /api/hearth/dm/open
+	// Body: { "user_id": "..." }
+	// Returns: { "dm": { ...direct_messages record... }, "created": bool }
+	// Members only, and not between users where either has blocked the other.
+	// Opening a DM that already exists returns it.
+	se.Router.POST("/api/hearth/dm/open", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		data := struct {
+			UserID string `json:"user_id"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+
+		if info.Auth.GetBool("guest") {
+			return e.ForbiddenError("Guests can't start DMs", nil)
+		}
+		if data.UserID == info.Auth.Id {
+			return e.BadRequestError("You can't message yourself", nil)
+		}
+
+		other, err := e.App.FindRecordById("users", data.UserID)
+		if err != nil {
+			return e.NotFoundError("User not found", nil)
+		}
+		if blockedEitherWay(e.App, info.Auth.Id, other.Id) {
+			return e.ForbiddenError(dmUnavailableMessage, nil)
+		}
+
+		dm, created, err := openDm(e.App, info.Auth.Id, other.Id)
+		if err != nil {
+			return e.BadRequestError("Failed to open DM", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"dm":      dm,
+			"created": created,
+		})
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// openDm returns the DM between two users, creating it if they don't have one
+// yet. Reports whether it was created.
+func openDm(app core.App, userID, otherID string) (*core.Record, bool, error) {
+	a, b := dmParticipants(userID, otherID)
+	find := func() (*core.Record, error) {
+		return app.FindFirstRecordByFilter(
+			"direct_messages",
+			"participant_a = {:a} && participant_b = {:b}",
+			dbx.Params{"a": a, "b": b},
+		)
+	}
+
+	if dm, err := find(); err == nil {
+		return dm, false, nil
+	}
+
+	collection, err := app.FindCollectionByNameOrId("direct_messages")
+	if err != nil {
+		return nil, false, err
+	}
+
+	dm := core.NewRecord(collection)
+	dm.Set("participant_a", a)
+	dm.Set("participant_b", b)
+	if err := app.Save(dm); err != nil {
+		// Both sides opened it at once and the unique index turned this row away —
+		// the one that got in is the conversation
+		if existing, findErr := find(); findErr == nil {
+			return existing, false, nil
+		}
+		return nil, false, err
+	}
+
+	return dm, true, nil
+}
+
+// dmParticipants puts a pair of user ids in direct_messages order.
+func dmParticipants(userID, otherID string) (string, string) {
+	if otherID < userID {
+		return otherID, userID
+	}
+	return userID, otherID
+}
@@ -0,0 +1,122 @@
+package hooks
+
+import (
+	"fmt"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxDmTimer caps direct_messages.disappear_after (seconds): one week.
+const maxDmTimer = 604800
+
+// RegisterDmTimers sets up disappearing-message timers for DMs. Either participant
+// can set direct_messages.disappear_after; new dm_messages then get an expires_at
+// that far out (server-side, like campfire messages) and the message GC sweeps
+// them. Messages already sent keep their expiry. Every change posts a system
+// notice into the conversation so neither side is surprised.
+func RegisterDmTimers(app *pocketbase.PocketBase) {
+	bindDmTimerHooks(app)
+}
+
+// bindDmTimerHooks binds the timer hooks.
+func bindDmTimerHooks(app core.App) {
+	// Clients cannot set their own expires_at — the server overrides it
+	app.OnRecordCreate("dm_messages").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("type") == "" {
+			e.Record.Set("type", "text")
+		}
+
+		dm, err := e.App.FindRecordById("direct_messages", e.Record.GetString("dm"))
+		if err != nil {
+			// No such DM — the relation field's own validation reports it
+			return e.Next()
+		}
+		e.Record.Set("expires_at", expiresAfter(dm.GetInt("disappear_after"), time.Now()))
+
+		return e.Next()
+	})
+
+	app.OnRecordUpdateRequest("direct_messages").BindFunc(func(e *core.RecordRequestEvent) error {
+		original := e.Record.Original()
+
+		// The timer is the only thing participants can change
+		for _, name := range e.Record.Collection().Fields.FieldNames() {
+			if name != "disappear_after" {
+				e.Record.Set(name, original.Get(name))
+			}
+		}
+
+		timer := e.Record.GetInt("disappear_after")
+		if timer == original.GetInt("disappear_after") {
+			return e.Next()
+		}
+		if timer != 0 && timer < minRoomTTL {
+			return validation.Errors{"disappear_after": validation.NewError(
+				"validation_dm_timer_min", fmt.Sprintf("Messages can disappear after %d seconds at the soonest", minRoomTTL),
+			)}
+		}
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		if e.Auth != nil && !e.HasSuperuserAuth() {
+			if err := postDmTimerNotice(e.App, e.Record, e.Auth, timer); err != nil {
+				e.App.Logger().Error("failed to post DM timer notice", "error", err, "dm", e.Record.Id)
+			}
+		}
+		return nil
+	})
+}
+
+// postDmTimerNotice writes the system notice for actor setting dm's timer to
+// timer seconds. A notice turning the timer on disappears along with the
+// messages it announces.
+func postDmTimerNotice(app core.App, dm, actor *core.Record, timer int) error {
+	collection, err := app.FindCollectionByNameOrId("dm_messages")
+	if err != nil {
+		return err
+	}
+
+	name := actor.GetString("display_name")
+	if name == "" {
+		name = "Wanderer"
+	}
+	body := name + " turned off disappearing messages"
+	if timer > 0 {
+		body = name + " set messages to disappear after " + formatDmTimer(timer)
+	}
+
+	notice := core.NewRecord(collection)
+	notice.Set("dm", dm.Id)
+	notice.Set("author", actor.Id)
+	notice.Set("type", "system")
+	notice.Set("body", body)
+	return app.Save(notice)
+}
+
+// formatDmTimer spells out a timer in its largest whole unit ("1 hour", "90 minutes").
+func formatDmTimer(seconds int) string {
+	units := []struct {
+		size int
+		name string
+	}{
+		{86400, "day"},
+		{3600, "hour"},
+		{60, "minute"},
+		{1, "second"},
+	}
+	for _, unit := range units {
+		if seconds%unit.size == 0 {
+			n := seconds / unit.size
+			if n == 1 {
+				return "1 " + unit.name
+			}
+			return fmt.Sprintf("%d %ss", n, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d seconds", seconds)
+}
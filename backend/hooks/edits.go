@@ -0,0 +1,116 @@
+package hooks
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterEdits enforces the message edit policy. Authors may change a message's
+// body — and nothing else — within an edit window set per room type. An edited
+// message is flagged, and (unless MESSAGE_REVISIONS=false) its previous body is
+// kept in message_revisions for the room's moderators. The message GC deletes a
+// message's revisions along with it (a raw DELETE, see sweepExpiredMessages), so
+// a campfire's history still fades on schedule.
+func RegisterEdits(app *pocketbase.PocketBase) {
+	bindEditHooks(app)
+}
+
+// bindEditHooks binds the edit guard.
+func bindEditHooks(app core.App) {
+	// Only the edit guard below may flag a message as edited
+	app.OnRecordCreate("messages").BindFunc(func(e *core.RecordEvent) error {
+		e.Record.Set("edited", false)
+		return e.Next()
+	})
+
+	app.OnRecordUpdateRequest("messages").BindFunc(func(e *core.RecordRequestEvent) error {
+		original := e.Record.Original()
+
+		// Superusers fix things from the dashboard without a window or a trace
+		if e.HasSuperuserAuth() {
+			return e.Next()
+		}
+
+		// Only the body is the author's to change; the rest is set by the server
+		for _, name := range e.Record.Collection().Fields.FieldNames() {
+			if name != "body" {
+				e.Record.Set(name, original.Get(name))
+			}
+		}
+
+		previous := original.GetString("body")
+		if SanitizeText(e.Record.GetString("body")) == previous {
+			return e.Next()
+		}
+
+		room, err := e.App.FindRecordById("rooms", original.GetString("room"))
+		if err != nil {
+			return e.NotFoundError("Room not found", err)
+		}
+		window := messageEditWindow(room.GetString("type"))
+		if time.Since(original.GetDateTime("created").Time()) > window {
+			return e.ForbiddenError("This message can no longer be edited", nil)
+		}
+
+		e.Record.Set("edited", true)
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		if messageRevisionsEnabled() {
+			if err := saveMessageRevision(e.App, e.Record.Id, previous); err != nil {
+				e.App.Logger().Error("failed to save message revision", "error", err, "message", e.Record.Id)
+			}
+		}
+		return nil
+	})
+}
+
+// saveMessageRevision keeps a message's previous body.
+func saveMessageRevision(app core.App, messageID, body string) error {
+	col, err := app.FindCollectionByNameOrId("message_revisions")
+	if err != nil {
+		return err
+	}
+
+	revision := core.NewRecord(col)
+	revision.Set("message", messageID)
+	revision.Set("body", body)
+	return app.Save(revision)
+}
+
+// messageEditWindow returns how long after posting a message in a room of the
+// given type can still be edited. Campfire messages burn quickly, so their window
+// is short; den history is meant to be read later and gets longer. A window of
+// 0 turns editing off for that room type.
+func messageEditWindow(roomType string) time.Duration {
+	if roomType == "den" {
+		return editWindowFromEnv("EDIT_WINDOW_DEN", time.Hour)
+	}
+	return editWindowFromEnv("EDIT_WINDOW_CAMPFIRE", 5*time.Minute)
+}
+
+// editWindowFromEnv reads an edit window in seconds (0 – 604800), falling back
+// to def when unset or out of range.
+func editWindowFromEnv(key string, def time.Duration) time.Duration {
+	s := os.Getenv(key)
+	if s == "" {
+		return def
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 0 || secs > 604800 {
+		return def
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// messageRevisionsEnabled reports whether edits keep the previous body
+// (MESSAGE_REVISIONS, default true).
+func messageRevisionsEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("MESSAGE_REVISIONS"))
+	return err != nil || enabled
+}
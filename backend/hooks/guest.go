@@ -0,0 +1,203 @@
+package hooks
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// guestEmailDomain is the placeholder domain for guest accounts. The users auth
+// collection requires an email; .invalid (RFC 2606) can never receive mail.
+const guestEmailDomain = "guest.invalid"
+
+// RegisterGuests sets up session-bound guest accounts (The Knock, step 4).
+// A guest is a users record flagged guest=true with no usable email/password,
+// scoped to the single room they were vouched into. Guests can "claim this key"
+// to become a full account; unclaimed guests are removed when their session expires.
+func RegisterGuests(app *pocketbase.PocketBase) {
+	// Sweep expired guests every 5 minutes
+	app.Cron().MustAdd("hearth_guest_sweep", "*/5 * * * *", func() {
+		removed, err := sweepExpiredGuests(app)
+		if err != nil {
+			app.Logger().Error("guest sweep failed", "error", err)
+		}
+		if removed > 0 {
+			app.Logger().Info("guest sweep", "removed", removed)
+		}
+	})
+
+	app.OnServe().BindFunc(guestRoutes)
+}
+
+// guestRoutes registers the guest claim endpoint.
+func guestRoutes(se *core.ServeEvent) error {
+	// POST /api/hearth/guest/claim
+	// Body: { "email": "...", "password": "...", "passwordConfirm": "..." }
+	// Upgrades the authenticated guest into a full account. The record id is
+	// kept, so memberships and message authorship carry over untouched.
+	se.Router.POST("/api/hearth/guest/claim", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		if !info.Auth.GetBool("guest") {
+			return e.BadRequestError("Only guest accounts can be claimed", nil)
+		}
+
+		data := struct {
+			Email           string `json:"email"`
+			Password        string `json:"password"`
+			PasswordConfirm string `json:"passwordConfirm"`
+			DisplayName     string `json:"display_name"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+		if data.Email == "" || data.Password == "" {
+			return e.BadRequestError("email and password are required", nil)
+		}
+		if data.Password != data.PasswordConfirm {
+			return e.BadRequestError("Passwords do not match", nil)
+		}
+
+		// Re-fetch so we don't save request-scoped auth state
+		guest, err := e.App.FindRecordById("users", info.Auth.Id)
+		if err != nil {
+			return e.NotFoundError("Guest not found", nil)
+		}
+
+		guest.SetEmail(data.Email)
+		guest.SetPassword(data.Password)
+		guest.Set("guest", false)
+		guest.Set("guest_room", "")
+		guest.Set("guest_expires_at", "")
+		if data.DisplayName != "" {
+			guest.Set("display_name", data.DisplayName)
+		}
+
+		if err := e.App.Save(guest); err != nil {
+			return e.BadRequestError("Failed to claim account", err)
+		}
+
+		e.App.Logger().Info("guest account claimed", "user", guest.Id)
+
+		// SetPassword rotates the token key, so hand back a fresh (refreshable) token
+		return apis.RecordAuthResponse(e, guest, "password", nil)
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// createGuestUser creates a session-bound guest account scoped to a single room.
+func createGuestUser(app core.App, displayName, roomID string) (*core.Record, error) {
+	usersCol, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return nil, err
+	}
+
+	localPart, err := generateRandomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	guest := core.NewRecord(usersCol)
+	guest.SetEmail("guest-" + localPart + "@" + guestEmailDomain)
+	guest.SetEmailVisibility(false)
+	guest.SetRandomPassword()
+	guest.Set("display_name", displayName)
+	guest.Set("role", "member")
+	guest.Set("status", "cozy")
+	guest.Set("guest", true)
+	guest.Set("guest_room", roomID)
+	guest.Set("guest_expires_at", time.Now().Add(getGuestSessionTTL()).UTC().Format(time.RFC3339))
+
+	if err := app.Save(guest); err != nil {
+		return nil, err
+	}
+	return guest, nil
+}
+
+// newGuestSessionToken issues a non-refreshable auth token that dies with the guest session.
+// Returns an empty token if the record isn't an active guest.
+func newGuestSessionToken(guest *core.Record) (string, error) {
+	if !guest.GetBool("guest") {
+		return "", nil
+	}
+
+	remaining := time.Until(guest.GetDateTime("guest_expires_at").Time())
+	if remaining <= 0 {
+		return "", nil
+	}
+
+	return guest.NewStaticAuthToken(remaining)
+}
+
+// guestDmsQuery selects the DMs the {:user} being swept takes part in.
+const guestDmsQuery = "SELECT id FROM direct_messages WHERE participant_a = {:user} OR participant_b = {:user}"
+
+// sweepExpiredGuests removes unclaimed guests whose session has expired, along with
+// their messages, DMs and memberships — a guest who never claimed their key leaves no trace.
+func sweepExpiredGuests(app core.App) (int, error) {
+	expired, err := app.FindRecordsByFilter(
+		"users",
+		"guest = true && guest_expires_at != '' && guest_expires_at <= {:now}",
+		"",
+		0,
+		0,
+		dbxParams("now", types.NowDateTime().String()),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, guest := range expired {
+		err := app.RunInTransaction(func(txApp core.App) error {
+			// Authorship relations are required and don't cascade — clear them first.
+			// Replies from others keep their quote snapshot but lose the dangling reply_to.
+			// A DM goes whole, the other participant's messages included: a member can
+			// open one with a guest, and raw deletes skip the cascade to dm_messages.
+			for _, q := range []string{
+				"UPDATE messages SET reply_to = '' WHERE reply_to IN (SELECT id FROM messages WHERE author = {:user})",
+				"UPDATE dm_messages SET reply_to = '' WHERE reply_to IN (SELECT id FROM dm_messages WHERE dm IN (" + guestDmsQuery + "))",
+				"DELETE FROM message_reactions WHERE message IN (SELECT id FROM messages WHERE author = {:user})",
+				"DELETE FROM message_revisions WHERE message IN (SELECT id FROM messages WHERE author = {:user})",
+				"DELETE FROM messages WHERE author = {:user}",
+				"DELETE FROM dm_messages WHERE dm IN (" + guestDmsQuery + ")",
+				"DELETE FROM direct_messages WHERE participant_a = {:user} OR participant_b = {:user}",
+			} {
+				if _, err := txApp.DB().NewQuery(q).Bind(dbxParams("user", guest.Id)).Execute(); err != nil {
+					return err
+				}
+			}
+
+			// Memberships, knocks, blocks and attachments (with their files) cascade with the user record
+			return txApp.Delete(guest)
+		})
+		if err != nil {
+			app.Logger().Error("failed to remove expired guest", "error", err, "user", guest.Id)
+			continue
+		}
+
+		presence.Remove(guest.Id)
+		removed++
+	}
+
+	return removed, nil
+}
+
+// getGuestSessionTTL reads the guest session length from env or returns default (24 hours).
+func getGuestSessionTTL() time.Duration {
+	s := os.Getenv("GUEST_SESSION_TTL")
+	if s == "" {
+		return 24 * time.Hour
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 300 || secs > 604800 {
+		return 24 * time.Hour
+	}
+	return time.Duration(secs) * time.Second
+}
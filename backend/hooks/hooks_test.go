@@ -1,16 +1,35 @@
 package hooks
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/disintegration/imaging"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/livekit/protocol/auth"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 // =============================================================================
@@ -338,6 +357,7 @@ func TestLiveKitTokenVoiceOnly(t *testing.T) {
 		"user123",
 		"Alice",
 		false, // no video
+		false, // not a voice admin
 	)
 	if err != nil {
 		t.Fatalf("token generation failed: %v", err)
@@ -354,7 +374,8 @@ func TestLiveKitTokenWithVideo(t *testing.T) {
 		"room-the-kitchen",
 		"user123",
 		"Alice",
-		true, // video allowed
+		true,  // video allowed
+		false, // not a voice admin
 	)
 	if err != nil {
 		t.Fatalf("token generation failed: %v", err)
@@ -364,6 +385,29 @@ func TestLiveKitTokenWithVideo(t *testing.T) {
 	}
 }
 
+func TestLiveKitTokenVoiceAdmin(t *testing.T) {
+	const secret = "test-secret-that-is-at-least-32-chars"
+
+	for _, admin := range []bool{false, true} {
+		token, err := generateLiveKitToken("test-api-key", secret, "room-the-kitchen", "user123", "Alice", false, admin)
+		if err != nil {
+			t.Fatalf("token generation failed: %v", err)
+		}
+
+		verifier, err := auth.ParseAPIToken(token)
+		if err != nil {
+			t.Fatalf("token should parse: %v", err)
+		}
+		_, grants, err := verifier.Verify(secret)
+		if err != nil {
+			t.Fatalf("token should verify: %v", err)
+		}
+		if grants.Video.RoomAdmin != admin {
+			t.Errorf("RoomAdmin = %v, want %v", grants.Video.RoomAdmin, admin)
+		}
+	}
+}
+
 // --- Helper utilities tests ---
 
 func TestDbxParams(t *testing.T) {
@@ -701,3 +745,4252 @@ func TestIsAuthPath(t *testing.T) {
 		t.Error("should match auth-refresh path")
 	}
 }
+
+// =============================================================================
+// The Knock — guest entry
+// =============================================================================
+
+func TestCanModerateRoom(t *testing.T) {
+	tests := []struct {
+		name       string
+		owner      string
+		keyholders []string
+		user       string
+		role       string
+		allowed    bool
+	}{
+		{"room owner", "owner1", nil, "owner1", "member", true},
+		{"homeowner", "owner1", nil, "user2", "homeowner", true},
+		{"delegated keyholder", "owner1", []string{"user2"}, "user2", "keyholder", true},
+		{"keyholder without delegation", "owner1", []string{"user3"}, "user2", "keyholder", false},
+		{"member listed as keyholder", "owner1", []string{"user2"}, "user2", "member", false},
+		{"plain member", "owner1", nil, "user2", "member", false},
+		{"anonymous", "owner1", nil, "", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := canModerateRoom(tt.owner, tt.keyholders, tt.user, tt.role); got != tt.allowed {
+			t.Errorf("%s: canModerateRoom = %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+}
+
+func TestKnockSecretMatches(t *testing.T) {
+	if !knockSecretMatches("abc123", "abc123") {
+		t.Error("matching secret should be accepted")
+	}
+	if knockSecretMatches("abc123", "abc124") {
+		t.Error("wrong secret should be rejected")
+	}
+	if knockSecretMatches("", "") {
+		t.Error("empty secrets must never match")
+	}
+}
+
+func TestIsKnockCreatePath(t *testing.T) {
+	if !isKnockCreatePath("/api/hearth/knock", "POST") {
+		t.Error("should match knock creation")
+	}
+	if isKnockCreatePath("/api/hearth/knock", "GET") {
+		t.Error("should not match GET")
+	}
+	if isKnockCreatePath("/api/hearth/knock/abc/approve", "POST") {
+		t.Error("should not match approve — hosts aren't knock-limited")
+	}
+}
+
+func TestKnock(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(knockRoutes)
+
+	original := os.Getenv("HMAC_SECRET_CURRENT")
+	defer os.Setenv("HMAC_SECRET_CURRENT", original)
+	os.Setenv("HMAC_SECRET_CURRENT", "test-secret-key-32-bytes-long!!!")
+
+	owner, ownerToken := createTestUser(t, app, "owner", "member")
+	roommate, roommateToken := createTestUser(t, app, "roommate", "member")
+	_, strangerToken := createTestUser(t, app, "stranger", "member")
+	room := createTestRoom(t, app, "front-porch", "den", owner.Id)
+	createTestMember(t, app, room, roommate, "member")
+
+	invite, err := encodeInviteToken(inviteClaims{RoomSlug: room.GetString("slug"), ExpiresAt: time.Now().Add(time.Hour).Unix()}, getCurrentSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	knockBody := func(name string) io.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"k":%q,"display_name":%q,"note":"hi!"}`, invite, name))
+	}
+
+	answered := createTestKnock(t, app, room, "Answered")
+	toApprove := createTestKnock(t, app, room, "Sarah")
+	toDeny := createTestKnock(t, app, room, "Mallory")
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "knocking needs a valid invite",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            strings.NewReader(`{"k":"forged.token","display_name":"Eve"}`),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "anyone with an invite can knock",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            knockBody("Dana"),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"pending"`, `"secret":"`, `"room_name":"front-porch"`},
+		},
+		{
+			Name:            "members don't knock on their own room",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            knockBody("Roommate"),
+			Headers:         auth(roommateToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Already a member"},
+		},
+		{
+			Name:            "polling needs the knock's secret",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/knock/" + toApprove.Id + "?secret=wrong",
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:               "polling with the secret shows the status",
+			Method:             http.MethodGet,
+			URL:                "/api/hearth/knock/" + toApprove.Id + "?secret=" + toApprove.GetString("secret"),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"status":"pending"`},
+			NotExpectedContent: []string{`"token"`},
+		},
+		{
+			Name:            "answering needs auth",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toApprove.Id + "/approve",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "strangers can't let anyone in",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toApprove.Id + "/approve",
+			Headers:         auth(strangerToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "plain members can't let anyone in",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toApprove.Id + "/approve",
+			Headers:         auth(roommateToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "plain members can't turn anyone away",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toDeny.Id + "/deny",
+			Headers:         auth(roommateToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "the owner lets them in",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toApprove.Id + "/approve",
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"approved"`, `"room_id":"` + room.Id + `"`},
+		},
+		{
+			Name:            "an approved guest's poll hands back their session",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/knock/" + toApprove.Id + "?secret=" + toApprove.GetString("secret"),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"approved"`, `"token":"`, `"guest":true`},
+		},
+		{
+			Name:            "the owner turns them away",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toDeny.Id + "/deny",
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"denied"`},
+		},
+		{
+			Name:            "a knock is answered once",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock/" + toDeny.Id + "/approve",
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"already been answered"},
+		},
+	}
+
+	answered.Set("status", knockDenied)
+	if err := app.Save(answered); err != nil {
+		t.Fatal(err)
+	}
+	scenarios = append(scenarios, tests.ApiScenario{
+		Name:            "a denied knock can't be let in later",
+		Method:          http.MethodPost,
+		URL:             "/api/hearth/knock/" + answered.Id + "/approve",
+		Headers:         auth(ownerToken),
+		ExpectedStatus:  400,
+		ExpectedContent: []string{"already been answered"},
+	})
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	if n, _ := app.CountRecords("knocks", dbx.HashExp{"display_name": "Dana", "status": knockPending}); n != 1 {
+		t.Errorf("expected Dana's knock to be pending, got %d", n)
+	}
+
+	// Approval mints a guest for the room, vouched for by whoever answered
+	approved, _ := app.FindRecordById("knocks", toApprove.Id)
+	if approved.GetString("answered_by") != owner.Id {
+		t.Errorf("approved knock answered_by = %q, want the owner", approved.GetString("answered_by"))
+	}
+	guest, err := app.FindRecordById("users", approved.GetString("user"))
+	if err != nil {
+		t.Fatalf("approval should create the guest account: %v", err)
+	}
+	if !guest.GetBool("guest") || guest.GetString("guest_room") != room.Id || guest.GetString("display_name") != "Sarah" {
+		t.Errorf("guest = %v/%q/%q, want a guest of the room named Sarah",
+			guest.GetBool("guest"), guest.GetString("guest_room"), guest.GetString("display_name"))
+	}
+	member, err := app.FindFirstRecordByFilter("room_members", "room = {:room} && user = {:user}",
+		dbx.Params{"room": room.Id, "user": guest.Id})
+	if err != nil {
+		t.Fatalf("approval should add a membership: %v", err)
+	}
+	if member.GetString("role") != inviteRoleGuest || member.GetString("vouched_by") != owner.Id {
+		t.Errorf("membership role/vouched_by = %q/%q, want guest/%s", member.GetString("role"), member.GetString("vouched_by"), owner.Id)
+	}
+
+	denied, _ := app.FindRecordById("knocks", toDeny.Id)
+	if denied.GetString("answered_by") != owner.Id || denied.GetString("user") != "" {
+		t.Errorf("denied knock answered_by/user = %q/%q, want the owner and no account", denied.GetString("answered_by"), denied.GetString("user"))
+	}
+}
+
+func TestKnockRefundsPoWToken(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(knockRoutes)
+
+	for key, value := range map[string]string{
+		"HMAC_SECRET_CURRENT": "test-secret-key-32-bytes-long!!!",
+		"KNOCK_REQUIRE_POW":   "true",
+	} {
+		original := os.Getenv(key)
+		defer os.Setenv(key, original)
+		os.Setenv(key, value)
+	}
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	roommate, roommateToken := createTestUser(t, app, "roommate", "member")
+	room := createTestRoom(t, app, "side-door", "den", owner.Id)
+	createTestMember(t, app, room, roommate, "member")
+
+	invite, err := encodeInviteToken(inviteClaims{RoomSlug: room.GetString("slug"), ExpiresAt: time.Now().Add(time.Hour).Unix()}, getCurrentSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"k":%q,"display_name":"Dana","pow_token":"knock-token"}`, invite)
+	powChallenges.issueToken("knock-token", time.Now().Add(time.Minute))
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "a knock that fails late keeps its token",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            strings.NewReader(body),
+			Headers:         map[string]string{"Authorization": roommateToken},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Already a member"},
+		},
+		{
+			Name:            "so it can knock again",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            strings.NewReader(body),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"pending"`},
+		},
+		{
+			Name:            "a knock that went through spent it",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            strings.NewReader(body),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"proof-of-work"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+func TestKnockRefundsTrackedInvite(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(knockRoutes)
+
+	original := os.Getenv("HMAC_SECRET_CURRENT")
+	defer os.Setenv("HMAC_SECRET_CURRENT", original)
+	os.Setenv("HMAC_SECRET_CURRENT", "test-secret-key-32-bytes-long!!!")
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	room := createTestRoom(t, app, "back-door", "den", owner.Id)
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	tracked, err := createTrackedInvite(app, room.Id, owner.Id, 1, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite, err := encodeInviteToken(inviteClaims{RoomSlug: room.GetString("slug"), ExpiresAt: expiresAt, InviteID: tracked.Id}, getCurrentSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	knockAs := func(name string) io.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"k":%q,"display_name":%q}`, invite, name))
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			// display_name is capped at 50 characters, so the knock won't save
+			Name:            "a knock that fails to save",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            knockAs(strings.Repeat("D", 51)),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Failed to knock"},
+		},
+		{
+			Name:            "leaves the one-use invite for the next knock",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            knockAs("Dana"),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"pending"`},
+		},
+		{
+			Name:            "which uses it up",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/knock",
+			Body:            knockAs("Eve"),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"used up"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+// createTestKnock saves a pending anonymous knock on room. Its secret is "secret-" + displayName.
+func createTestKnock(t testing.TB, app core.App, room *core.Record, displayName string) *core.Record {
+	t.Helper()
+
+	col, err := app.FindCollectionByNameOrId("knocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knock := core.NewRecord(col)
+	knock.Set("room", room.Id)
+	knock.Set("display_name", displayName)
+	knock.Set("status", knockPending)
+	knock.Set("grant_role", inviteRoleGuest)
+	knock.Set("secret", "secret-"+displayName)
+	if err := app.Save(knock); err != nil {
+		t.Fatalf("failed to create knock %s: %v", displayName, err)
+	}
+	return knock
+}
+
+// =============================================================================
+// Guest accounts
+// =============================================================================
+
+func TestGuestSessionTTL(t *testing.T) {
+	original := os.Getenv("GUEST_SESSION_TTL")
+	defer os.Setenv("GUEST_SESSION_TTL", original)
+
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", 24 * time.Hour},
+		{"3600", time.Hour},
+		{"60", 24 * time.Hour},      // below 5-minute floor
+		{"9999999", 24 * time.Hour}, // above 7-day cap
+		{"soon", 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("GUEST_SESSION_TTL", tt.env)
+		if got := getGuestSessionTTL(); got != tt.want {
+			t.Errorf("GUEST_SESSION_TTL=%q: got %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestGuestClaim(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(guestRoutes)
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	_, memberToken := createTestUser(t, app, "member", "member")
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+
+	guest, err := createGuestUser(app, "Sarah", room.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addRoomMember(app, room.Id, guest.Id, inviteRoleGuest, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	said := createTestMessage(t, app, room, guest, time.Hour)
+	guestToken, err := newGuestSessionToken(guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(email, password, confirm string) io.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"email":%q,"password":%q,"passwordConfirm":%q,"display_name":"Sarah K"}`, email, password, confirm))
+	}
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "requires auth",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/guest/claim",
+			Body:            claim("sarah@hearth.test", "correct-horse-battery", "correct-horse-battery"),
+			ExpectedStatus:  401,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "only guests can claim",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/guest/claim",
+			Body:            claim("member2@hearth.test", "correct-horse-battery", "correct-horse-battery"),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Only guest accounts"},
+		},
+		{
+			Name:            "passwords must match",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/guest/claim",
+			Body:            claim("sarah@hearth.test", "correct-horse-battery", "correct-horse-staple"),
+			Headers:         auth(guestToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"do not match"},
+		},
+		{
+			Name:            "a guest claims their key",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/guest/claim",
+			Body:            claim("sarah@hearth.test", "correct-horse-battery", "correct-horse-battery"),
+			Headers:         auth(guestToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"token":"`, `"id":"` + guest.Id + `"`, `"guest":false`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	// Same record, no longer a guest, with everything it had as one
+	claimed, err := app.FindRecordById("users", guest.Id)
+	if err != nil {
+		t.Fatalf("claimed account should keep the guest's id: %v", err)
+	}
+	if claimed.GetBool("guest") || claimed.GetString("guest_room") != "" || !claimed.GetDateTime("guest_expires_at").IsZero() {
+		t.Errorf("claim should clear guest/guest_room/guest_expires_at, got %v/%q/%v",
+			claimed.GetBool("guest"), claimed.GetString("guest_room"), claimed.GetDateTime("guest_expires_at"))
+	}
+	if claimed.Email() != "sarah@hearth.test" || !claimed.ValidatePassword("correct-horse-battery") {
+		t.Error("claimed account should sign in with the new email and password")
+	}
+	if claimed.GetString("display_name") != "Sarah K" {
+		t.Errorf("display_name = %q, want the one picked on claim", claimed.GetString("display_name"))
+	}
+	if !isRoomMember(app, room.Id, guest.Id) {
+		t.Error("claimed account should keep its membership")
+	}
+	if msg, err := app.FindRecordById("messages", said.Id); err != nil || msg.GetString("author") != guest.Id {
+		t.Errorf("claimed account should keep authorship of its messages: %v", err)
+	}
+
+	// A claimed account is no longer swept with the guests
+	claimed.Set("guest_expires_at", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	if err := app.Save(claimed); err != nil {
+		t.Fatal(err)
+	}
+	if removed, _ := sweepExpiredGuests(app); removed != 0 {
+		t.Errorf("sweep removed %d accounts, want none once claimed", removed)
+	}
+}
+
+func TestSweepExpiredGuests(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	friend, _ := createTestUser(t, app, "friend", "member")
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+
+	guestOf := func(name string, expiresAt time.Time) *core.Record {
+		guest, _ := createTestUser(t, app, name, "member")
+		guest.Set("guest", true)
+		guest.Set("guest_room", room.Id)
+		guest.Set("guest_expires_at", expiresAt.UTC().Format(time.RFC3339))
+		if err := app.Save(guest); err != nil {
+			t.Fatal(err)
+		}
+		createTestMember(t, app, room, guest, inviteRoleGuest)
+		return guest
+	}
+	gone := guestOf("gone", time.Now().Add(-time.Minute))
+	staying := guestOf("staying", time.Now().Add(time.Hour))
+
+	createTestMessage(t, app, room, gone, time.Hour)
+	createTestMessage(t, app, room, staying, time.Hour)
+
+	dmMessagesCol, _ := app.FindCollectionByNameOrId("dm_messages")
+	writeDm := func(dm, author *core.Record, body string) {
+		msg := core.NewRecord(dmMessagesCol)
+		msg.Set("dm", dm.Id)
+		msg.Set("author", author.Id)
+		msg.Set("body", body)
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A member opened a DM with the guest and did most of the talking
+	guestDm, _, err := openDm(app, friend.Id, gone.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDm(guestDm, friend, "welcome in")
+	writeDm(guestDm, gone, "thanks!")
+	writeDm(guestDm, friend, "stay a while")
+
+	otherDm, _, err := openDm(app, friend.Id, owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDm(otherDm, friend, "who was that?")
+
+	removed, err := sweepExpiredGuests(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 guest removed, got %d", removed)
+	}
+
+	if _, err := app.FindRecordById("users", gone.Id); err == nil {
+		t.Error("expired guest should be removed")
+	}
+	if _, err := app.FindRecordById("users", staying.Id); err != nil {
+		t.Errorf("guest with time left should stay: %v", err)
+	}
+
+	count := func(collection string, exp dbx.Expression) int64 {
+		n, err := app.CountRecords(collection, exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count("room_members", dbx.HashExp{"user": gone.Id}); n != 0 {
+		t.Errorf("expired guest's memberships should go, %d left", n)
+	}
+	if n := count("room_members", dbx.HashExp{"user": staying.Id}); n != 1 {
+		t.Errorf("other guest's membership should stay, got %d", n)
+	}
+	if n := count("messages", dbx.HashExp{"author": gone.Id}); n != 0 {
+		t.Errorf("expired guest's messages should go, %d left", n)
+	}
+	if n := count("direct_messages", dbx.HashExp{"id": guestDm.Id}); n != 0 {
+		t.Error("expired guest's DM should go")
+	}
+	if n := count("dm_messages", dbx.HashExp{"dm": guestDm.Id}); n != 0 {
+		t.Errorf("the other participant's messages should go with the DM, %d left", n)
+	}
+	var indexed int
+	if err := app.DB().NewQuery("SELECT COUNT(*) FROM dm_messages_search WHERE dm = {:dm}").
+		Bind(dbx.Params{"dm": guestDm.Id}).Row(&indexed); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 0 {
+		t.Errorf("the DM's search rows should go with it, %d left", indexed)
+	}
+	if n := count("dm_messages", dbx.HashExp{"dm": otherDm.Id}); n != 1 {
+		t.Errorf("DMs between members should be untouched, got %d messages", n)
+	}
+}
+
+// =============================================================================
+// Registration gate — PoW tokens + invites
+// =============================================================================
+
+func TestPoWTokenSingleUse(t *testing.T) {
+	ps := &powStore{challenges: make(map[string]*powChallenge), tokens: make(map[string]time.Time)}
+
+	ps.issueToken("tok", time.Now().Add(time.Minute))
+
+	if !ps.consumeToken("tok") {
+		t.Fatal("fresh token should be spendable")
+	}
+	if ps.consumeToken("tok") {
+		t.Error("token must not be spendable twice")
+	}
+	if ps.consumeToken("") {
+		t.Error("empty token must never be spendable")
+	}
+}
+
+func TestPoWTokenExpired(t *testing.T) {
+	ps := &powStore{challenges: make(map[string]*powChallenge), tokens: make(map[string]time.Time)}
+
+	ps.issueToken("stale", time.Now().Add(-time.Second))
+	if ps.consumeToken("stale") {
+		t.Error("expired token should be rejected")
+	}
+
+	ps.issueToken("stale2", time.Now().Add(-time.Second))
+	ps.sweep()
+	if len(ps.tokens) != 0 {
+		t.Errorf("sweep should drop expired tokens, %d left", len(ps.tokens))
+	}
+}
+
+func TestRegistrationGate(t *testing.T) {
+	original := os.Getenv("HMAC_SECRET_CURRENT")
+	defer os.Setenv("HMAC_SECRET_CURRENT", original)
+	os.Setenv("HMAC_SECRET_CURRENT", "test-secret-key-32-bytes-long!!!")
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	url := generateInviteURL("the-den", expiresAt, getCurrentSecret(), "hearth.example")
+
+	body := map[string]any{
+		"invite_r":  "the-den",
+		"invite_t":  strconv.FormatInt(expiresAt, 10),
+		"invite_s":  extractParam(url, "s="),
+		"pow_token": "gate-token",
+	}
+
+	// No token issued yet
+	if _, err := checkRegistrationGate(nil, body); err == nil {
+		t.Error("unissued PoW token should be rejected")
+	}
+
+	powChallenges.issueToken("gate-token", time.Now().Add(time.Minute))
+
+	// Bad invite must not burn the token
+	bad := map[string]any{"invite_r": "the-den", "invite_t": "1", "invite_s": "00", "pow_token": "gate-token"}
+	if _, err := checkRegistrationGate(nil, bad); err == nil {
+		t.Error("invalid invite should be rejected")
+	}
+
+	refund, err := checkRegistrationGate(nil, body)
+	if err != nil {
+		t.Fatalf("valid invite + token should pass: %v", err)
+	}
+	if _, err := checkRegistrationGate(nil, body); err == nil {
+		t.Error("replayed PoW token should be rejected")
+	}
+
+	// A sign-up that failed to save hands the token back
+	refund()
+	if _, err := checkRegistrationGate(nil, body); err != nil {
+		t.Errorf("refunded PoW token should pass again: %v", err)
+	}
+}
+
+func TestRegistrationGateRefundsTrackedInvite(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	original := os.Getenv("HMAC_SECRET_CURRENT")
+	defer os.Setenv("HMAC_SECRET_CURRENT", original)
+	os.Setenv("HMAC_SECRET_CURRENT", "test-secret-key-32-bytes-long!!!")
+
+	owner, _ := createTestUser(t, app, "Owner", "homeowner")
+	room := createTestRoom(t, app, "refund-room", "den", owner.Id)
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	invite, err := createTrackedInvite(app, room.Id, owner.Id, 1, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := encodeInviteToken(inviteClaims{RoomSlug: room.GetString("slug"), ExpiresAt: expiresAt, InviteID: invite.Id}, getCurrentSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]any{"invite_k": token, "pow_token": "tracked-gate-token"}
+
+	uses := func() int {
+		record, err := app.FindRecordById("invites", invite.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record.GetInt("uses")
+	}
+
+	powChallenges.issueToken("tracked-gate-token", time.Now().Add(time.Minute))
+	refund, err := checkRegistrationGate(app, body)
+	if err != nil {
+		t.Fatalf("tracked invite + token should pass: %v", err)
+	}
+	if n := uses(); n != 1 {
+		t.Fatalf("passing the gate should spend the invite's use, uses = %d", n)
+	}
+
+	// The sign-up failed (say, a taken email): the one-use invite works again
+	refund()
+	if n := uses(); n != 0 {
+		t.Errorf("refund should give the use back, uses = %d", n)
+	}
+	if _, err := checkRegistrationGate(app, body); err != nil {
+		t.Errorf("refunded invite and token should pass again: %v", err)
+	}
+	if n := uses(); n != 1 {
+		t.Errorf("second sign-up should spend the use, uses = %d", n)
+	}
+}
+
+// =============================================================================
+// Adaptive PoW difficulty
+// =============================================================================
+
+func TestScaledBits(t *testing.T) {
+	tests := []struct {
+		n, threshold, want int
+	}{
+		{0, 64, 0},
+		{63, 64, 0},
+		{64, 64, 1},
+		{128, 64, 2},
+		{1024, 64, 5},
+		{5, 0, 0}, // disabled signal
+	}
+	for _, tt := range tests {
+		if got := scaledBits(tt.n, tt.threshold); got != tt.want {
+			t.Errorf("scaledBits(%d, %d) = %d, want %d", tt.n, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestAdaptivePowPolicyCalm(t *testing.T) {
+	p := defaultAdaptivePowPolicy()
+	p.Base = 18
+
+	if d := p.Difficulty(PowSignals{Outstanding: 5}); d != 18 {
+		t.Errorf("calm House should issue base difficulty, got %d", d)
+	}
+}
+
+func TestAdaptivePowPolicyFloodFromOneIP(t *testing.T) {
+	rl := NewRateLimiter()
+	config := RateLimitConfig{MaxTokens: 10, RefillRate: 0}
+
+	// Simulate a single client hammering the challenge endpoint
+	for i := 0; i < 200; i++ {
+		if !rl.Allow("api:203.0.113.9", config) {
+			rl.RecordRejection("203.0.113.9")
+		}
+	}
+
+	p := defaultAdaptivePowPolicy()
+	p.Base = 18
+	d := p.Difficulty(PowSignals{RateLimitRejections: rl.RecentRejections("203.0.113.9")})
+	if d < 24 {
+		t.Errorf("flooding IP should face 24+ bits, got %d", d)
+	}
+
+	// A bystander on another IP is unaffected
+	if d := p.Difficulty(PowSignals{RateLimitRejections: rl.RecentRejections("198.51.100.1")}); d != 18 {
+		t.Errorf("honest IP should keep base difficulty, got %d", d)
+	}
+}
+
+func TestAdaptivePowPolicyDistributedFlood(t *testing.T) {
+	ps := &powStore{
+		challenges: make(map[string]*powChallenge),
+		tokens:     make(map[string]time.Time),
+		failures:   make(map[string]*abuseWindow),
+	}
+
+	// Thousands of unsolved challenges from a botnet — no single IP stands out
+	for i := 0; i < 5000; i++ {
+		id := fmt.Sprintf("c%d", i)
+		ps.challenges[id] = &powChallenge{ID: id, Difficulty: 18, ExpiresAt: time.Now().Add(time.Minute)}
+	}
+
+	p := defaultAdaptivePowPolicy()
+	p.Base = 18
+	d := p.Difficulty(PowSignals{Outstanding: ps.outstanding()})
+	if d < 24 {
+		t.Errorf("distributed flood should push difficulty to 24+, got %d", d)
+	}
+	if d > p.Max {
+		t.Errorf("difficulty %d exceeds policy max %d", d, p.Max)
+	}
+}
+
+func TestAdaptivePowPolicyFailedVerifies(t *testing.T) {
+	ps := &powStore{
+		challenges: make(map[string]*powChallenge),
+		tokens:     make(map[string]time.Time),
+		failures:   make(map[string]*abuseWindow),
+	}
+	for i := 0; i < 12; i++ {
+		ps.recordFailure("203.0.113.9")
+	}
+
+	p := defaultAdaptivePowPolicy()
+	p.Base = 18
+	d := p.Difficulty(PowSignals{FailedVerifies: ps.recentFailures("203.0.113.9")})
+	if d <= 18 {
+		t.Errorf("repeated bad solutions should raise difficulty, got %d", d)
+	}
+}
+
+func TestFixedPowPolicy(t *testing.T) {
+	defer SetPowDifficultyPolicy(defaultAdaptivePowPolicy())
+
+	SetPowDifficultyPolicy(FixedPowPolicy(12))
+	if d := nextPowDifficulty("203.0.113.9"); d != 12 {
+		t.Errorf("fixed policy should issue 12, got %d", d)
+	}
+	if powDifficultyCurrent.Load() != 12 {
+		t.Errorf("difficulty gauge should track last issued challenge, got %d", powDifficultyCurrent.Load())
+	}
+}
+
+// =============================================================================
+// Memory-hard PoW (Argon2id)
+// =============================================================================
+
+// testArgon2Params are cheap parameters so tests can brute-force solutions quickly.
+var testArgon2Params = argon2Params{Memory: 1024, Time: 1, Threads: 1, KeyLen: 32}
+
+func TestArgon2PoWVerifyValid(t *testing.T) {
+	challengeID := "argon2-challenge-id"
+	difficulty := 4
+
+	nonce := ""
+	for i := 0; i < 10_000; i++ {
+		candidate := fmt.Sprintf("%d", i)
+		if verifyArgon2PoW(challengeID, candidate, difficulty, testArgon2Params) {
+			nonce = candidate
+			break
+		}
+	}
+	if nonce == "" {
+		t.Fatal("failed to solve Argon2id challenge")
+	}
+
+	c := &powChallenge{ID: challengeID, Algorithm: powAlgorithmArgon2id, Difficulty: difficulty, Argon2: testArgon2Params}
+	if ok, err := verifyChallenge(context.Background(), c, nonce); !ok || err != nil {
+		t.Errorf("valid Argon2id solution should verify via dispatch, got %v, %v", ok, err)
+	}
+
+	// Unknown algorithms are rejected outright
+	c.Algorithm = "md5"
+	if ok, _ := verifyChallenge(context.Background(), c, nonce); ok {
+		t.Error("unknown algorithm must never verify")
+	}
+}
+
+func TestVerifyChallengeLegacySHA256(t *testing.T) {
+	// Challenges without an algorithm are SHA256 — existing clients keep working
+	nonce := solvePoW("legacy-challenge", 8)
+	c := &powChallenge{ID: "legacy-challenge", Difficulty: 8}
+	if ok, err := verifyChallenge(context.Background(), c, nonce); !ok || err != nil {
+		t.Errorf("legacy SHA256 challenge should verify, got %v, %v", ok, err)
+	}
+}
+
+func TestArgon2VerifyGivesUpWhenBusy(t *testing.T) {
+	// Occupy every slot, as a flood of concurrent verifies would
+	for i := 0; i < cap(argon2VerifySlots); i++ {
+		argon2VerifySlots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(argon2VerifySlots); i++ {
+			<-argon2VerifySlots
+		}
+	}()
+
+	c := &powChallenge{ID: "busy-challenge", Algorithm: powAlgorithmArgon2id, Difficulty: 1, Argon2: testArgon2Params}
+
+	// A request that goes away stops waiting straight off
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := verifyChallenge(ctx, c, "0"); !errors.Is(err, errArgon2Busy) {
+		t.Errorf("cancelled request should give up with errArgon2Busy, got %v", err)
+	}
+
+	// One that stays waits argon2SlotWait at most
+	start := time.Now()
+	if _, err := verifyChallenge(context.Background(), c, "0"); !errors.Is(err, errArgon2Busy) {
+		t.Errorf("verify should give up with errArgon2Busy, got %v", err)
+	}
+	if waited := time.Since(start); waited > argon2SlotWait+time.Second {
+		t.Errorf("verify waited %v for a slot, want at most %v", waited, argon2SlotWait)
+	}
+
+	// SHA256 challenges don't need a slot
+	legacy := &powChallenge{ID: "busy-legacy", Difficulty: 8}
+	if ok, err := verifyChallenge(ctx, legacy, solvePoW("busy-legacy", 8)); !ok || err != nil {
+		t.Errorf("SHA256 verify shouldn't wait on Argon2id slots, got %v, %v", ok, err)
+	}
+}
+
+func TestArgon2DifficultyMapping(t *testing.T) {
+	if d := argon2Difficulty(20); d != 4 {
+		t.Errorf("base 20 should map to 4 Argon2id bits, got %d", d)
+	}
+	if d := argon2Difficulty(8); d != 1 {
+		t.Errorf("difficulty should floor at 1, got %d", d)
+	}
+}
+
+func TestHasLeadingZeroBitsOverflow(t *testing.T) {
+	if hasLeadingZeroBits(make([]byte, 4), 33) {
+		t.Error("difficulty beyond hash length must not verify")
+	}
+}
+
+// TestPoWVerificationCost compares per-guess cost. The point of Argon2id is that
+// one guess is orders of magnitude more expensive than a SHA256 guess — and that
+// expense is memory, which GPUs can't parallelize cheaply.
+func TestPoWVerificationCost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cost comparison runs benchmarks")
+	}
+
+	sha := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			verifyPoW("cost-challenge", strconv.Itoa(i), 20)
+		}
+	})
+	argon := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			verifyArgon2PoW("cost-challenge", strconv.Itoa(i), 4, defaultArgon2Params)
+		}
+	})
+
+	ratio := float64(argon.NsPerOp()) / float64(sha.NsPerOp())
+	t.Logf("sha256: %d ns/op, argon2id: %d ns/op (%.0fx)", sha.NsPerOp(), argon.NsPerOp(), ratio)
+
+	if ratio < 1000 {
+		t.Errorf("Argon2id guess should cost >1000x a SHA256 guess, got %.0fx", ratio)
+	}
+}
+
+// =============================================================================
+// Tracked invites
+// =============================================================================
+
+func TestTrackedInviteSignature(t *testing.T) {
+	secret := []byte("test-secret-key-32-bytes-long!!!")
+	expiresAt := time.Now().Add(time.Hour).Unix()
+
+	payload := "the-kitchen." + strconv.FormatInt(expiresAt, 10) + ".inv123"
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	if !validateTrackedInvite("the-kitchen", expiresAt, "inv123", sig, [][]byte{secret}) {
+		t.Error("tracked invite should be valid")
+	}
+	// Swapping in another record id breaks the signature
+	if validateTrackedInvite("the-kitchen", expiresAt, "inv999", sig, [][]byte{secret}) {
+		t.Error("tracked invite with swapped id should not be valid")
+	}
+	// A tracked signature is not a stateless one (can't strip &i= to dodge revocation)
+	if validateInvite("the-kitchen", expiresAt, sig, [][]byte{secret}) {
+		t.Error("tracked signature must not validate as a stateless invite")
+	}
+}
+
+func TestInviteRecordUsable(t *testing.T) {
+	if err := inviteRecordUsable(false, 0, 0); err != nil {
+		t.Errorf("unlimited invite should be usable: %v", err)
+	}
+	if err := inviteRecordUsable(false, 2, 3); err != nil {
+		t.Errorf("invite with uses left should be usable: %v", err)
+	}
+	if err := inviteRecordUsable(false, 3, 3); err != errInviteExhausted {
+		t.Errorf("used-up invite: got %v, want %v", err, errInviteExhausted)
+	}
+	if err := inviteRecordUsable(true, 0, 0); err != errInviteRevoked {
+		t.Errorf("revoked invite: got %v, want %v", err, errInviteRevoked)
+	}
+}
+
+func TestCanManageInvites(t *testing.T) {
+	if !canManageInvites("owner1", "owner1", "member") {
+		t.Error("room owner should manage invites")
+	}
+	if !canManageInvites("owner1", "user2", "homeowner") {
+		t.Error("homeowner should manage invites")
+	}
+	if canManageInvites("owner1", "user2", "keyholder") {
+		t.Error("keyholder without ownership should not manage every invite")
+	}
+}
+
+// =============================================================================
+// Versioned (v2) invite tokens
+// =============================================================================
+
+func TestInviteTokenRoundtrip(t *testing.T) {
+	secret := []byte("test-secret-key-32-bytes-long!!!")
+	claims := inviteClaims{
+		RoomSlug:    "the-kitchen",
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+		Role:        inviteRoleMember,
+		AutoApprove: true,
+		InvitedBy:   "user1",
+		InviteID:    "inv123",
+	}
+
+	token, err := encodeInviteToken(claims, secret)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	got, err := decodeInviteToken(token, [][]byte{secret})
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	claims.Version = inviteTokenVersion
+	if *got != claims {
+		t.Errorf("roundtrip mismatch: got %+v, want %+v", *got, claims)
+	}
+}
+
+func TestInviteTokenTampered(t *testing.T) {
+	secret := []byte("test-secret-key-32-bytes-long!!!")
+	token, _ := encodeInviteToken(inviteClaims{
+		RoomSlug:  "the-kitchen",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Role:      inviteRoleGuest,
+	}, secret)
+
+	// Re-encode the payload with an upgraded role but keep the original signature
+	_, sig, _ := strings.Cut(token, ".")
+	forged, _ := encodeInviteToken(inviteClaims{
+		RoomSlug:  "the-kitchen",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Role:      inviteRoleMember,
+	}, []byte("attacker-secret"))
+	payload, _, _ := strings.Cut(forged, ".")
+
+	if _, err := decodeInviteToken(payload+"."+sig, [][]byte{secret}); err == nil {
+		t.Error("token with a tampered payload should not verify")
+	}
+	if _, err := decodeInviteToken("not-a-token", [][]byte{secret}); err == nil {
+		t.Error("malformed token should not verify")
+	}
+}
+
+func TestInviteTokenKeyRotation(t *testing.T) {
+	oldSecret := []byte("old-secret-key-32-bytes-long!!!!")
+	newSecret := []byte("new-secret-key-32-bytes-long!!!!")
+	token, _ := encodeInviteToken(inviteClaims{
+		RoomSlug:  "the-kitchen",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}, oldSecret)
+
+	if _, err := decodeInviteToken(token, [][]byte{newSecret, oldSecret}); err != nil {
+		t.Errorf("token signed with old secret should verify during rotation: %v", err)
+	}
+	if _, err := decodeInviteToken(token, [][]byte{newSecret}); err == nil {
+		t.Error("token signed with a retired secret should not verify")
+	}
+}
+
+func TestInviteTokenExpired(t *testing.T) {
+	secret := []byte("test-secret-key-32-bytes-long!!!")
+	token, _ := encodeInviteToken(inviteClaims{
+		RoomSlug:  "the-kitchen",
+		ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+	}, secret)
+
+	if _, err := decodeInviteToken(token, [][]byte{secret}); err == nil {
+		t.Error("expired token should not verify")
+	}
+}
+
+func TestInviteSignatureV1Compat(t *testing.T) {
+	secret := []byte("test-secret-key-32-bytes-long!!!")
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	url := generateInviteURL("the-kitchen", expiresAt, secret, "hearth.example")
+
+	claims, err := verifyInviteSignature(inviteParams{
+		RoomSlug:  "the-kitchen",
+		Timestamp: strconv.FormatInt(expiresAt, 10),
+		Signature: extractParam(url, "s="),
+	}, [][]byte{secret})
+	if err != nil {
+		t.Fatalf("v1 link should still verify: %v", err)
+	}
+	if claims.Version != 1 || claims.grantedRole() != inviteRoleGuest || claims.AutoApprove {
+		t.Errorf("v1 link should grant defaults, got %+v", *claims)
+	}
+}
+
+func TestInviteGrantedRoleDefault(t *testing.T) {
+	if r := (&inviteClaims{}).grantedRole(); r != inviteRoleGuest {
+		t.Errorf("empty role should default to guest, got %q", r)
+	}
+	if r := (&inviteClaims{Role: "owner"}).grantedRole(); r != inviteRoleGuest {
+		t.Errorf("unknown role should fall back to guest, got %q", r)
+	}
+	if r := (&inviteClaims{Role: inviteRoleMember}).grantedRole(); r != inviteRoleMember {
+		t.Errorf("member role should be kept, got %q", r)
+	}
+}
+
+// =============================================================================
+// Invite key rotation
+// =============================================================================
+
+func TestInviteKeySetGraceWindow(t *testing.T) {
+	now := time.Now()
+	set := &inviteKeySet{
+		Current:       hex.EncodeToString([]byte("new-secret")),
+		Previous:      hex.EncodeToString([]byte("old-secret")),
+		PreviousUntil: now.Add(time.Hour).Unix(),
+	}
+
+	secrets := set.secrets(now)
+	if len(secrets) != 2 || string(secrets[0]) != "new-secret" || string(secrets[1]) != "old-secret" {
+		t.Fatalf("within grace: want [new old], got %q", secrets)
+	}
+
+	secrets = set.secrets(now.Add(2 * time.Hour))
+	if len(secrets) != 1 || string(secrets[0]) != "new-secret" {
+		t.Errorf("after grace: want [new], got %q", secrets)
+	}
+}
+
+func TestGetSecretsFallsBackToEnv(t *testing.T) {
+	t.Setenv("HMAC_SECRET_CURRENT", hex.EncodeToString([]byte("env-current")))
+	t.Setenv("HMAC_SECRET_OLD", "raw-old")
+
+	secrets := getSecrets()
+	if len(secrets) != 2 || string(secrets[0]) != "env-current" || string(secrets[1]) != "raw-old" {
+		t.Errorf("without a stored key set, want env secrets, got %q", secrets)
+	}
+	if string(getCurrentSecret()) != "env-current" {
+		t.Errorf("current secret should come from env, got %q", getCurrentSecret())
+	}
+}
+
+func TestInviteKeyGraceEnv(t *testing.T) {
+	t.Setenv("INVITE_KEY_GRACE", "")
+	if g := getInviteKeyGrace(); g != 7*24*time.Hour {
+		t.Errorf("default grace should be 7 days, got %v", g)
+	}
+	t.Setenv("INVITE_KEY_GRACE", "3600")
+	if g := getInviteKeyGrace(); g != time.Hour {
+		t.Errorf("grace should be 1h, got %v", g)
+	}
+	t.Setenv("INVITE_KEY_GRACE", "99999999")
+	if g := getInviteKeyGrace(); g != 7*24*time.Hour {
+		t.Errorf("out-of-range grace should fall back to default, got %v", g)
+	}
+}
+
+// =============================================================================
+// Invite short codes & QR
+// =============================================================================
+
+func TestNewInviteCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := newInviteCode()
+		if err != nil {
+			t.Fatalf("newInviteCode failed: %v", err)
+		}
+		if normalizeInviteCode(code) != code {
+			t.Fatalf("generated code %q is not canonical", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("expected 100 unique codes, got %d", len(seen))
+	}
+}
+
+func TestNormalizeInviteCode(t *testing.T) {
+	cases := map[string]string{
+		"7K3M9QXA":  "7K3M9QXA",
+		"7k3m-9qxa": "7K3M9QXA",
+		"7K3M 9QXA": "7K3M9QXA",
+		"IL0O-ABCD": "1100ABCD",
+		"7K3M9QX":   "", // too short
+		"7K3M9QXAB": "", // too long
+		"7K3M9QXU":  "", // U isn't in the alphabet
+		"7K3M9QX!":  "",
+		"":          "",
+	}
+	for in, want := range cases {
+		if got := normalizeInviteCode(in); got != want {
+			t.Errorf("normalizeInviteCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderInviteQR(t *testing.T) {
+	url := "https://hearth.example/join?k=eyJ2IjoyfQ.c2ln"
+
+	png, err := renderInviteQR(url, inviteQRPNG)
+	if err != nil || !strings.HasPrefix(png, "data:image/png;base64,") {
+		t.Errorf("png QR: got %.40q, %v", png, err)
+	}
+
+	svg, err := renderInviteQR(url, inviteQRSVG)
+	if err != nil || !strings.HasPrefix(svg, "data:image/svg+xml;base64,") {
+		t.Errorf("svg QR: got %.40q, %v", svg, err)
+	}
+
+	if _, err := renderInviteQR(url, "gif"); err == nil {
+		t.Error("unsupported QR format should error")
+	}
+}
+
+func TestQRBitmapSVG(t *testing.T) {
+	svg := qrBitmapSVG([][]bool{{true, false}, {false, true}})
+	if !strings.Contains(svg, `viewBox="0 0 2 2"`) {
+		t.Errorf("svg should size its viewBox to the bitmap: %s", svg)
+	}
+	if !strings.Contains(svg, "M0 0h1v1h-1zM1 1h1v1h-1z") {
+		t.Errorf("svg should draw one square per dark module: %s", svg)
+	}
+}
+
+// =============================================================================
+// Roles (integration — PocketBase test app)
+// =============================================================================
+
+// newTestHouse returns a fresh PocketBase test app with every Hearth collection
+// and API rule in place.
+func newTestHouse(t testing.TB) *tests.TestApp {
+	t.Helper()
+
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create test app: %v", err)
+	}
+
+	for _, ensure := range []func(core.App) error{
+		ensureUsersFields,
+		ensureRoomsCollection,
+		ensureUsersGuestRoom,
+		ensureMessagesCollection,
+		ensureRoomMembersCollection,
+		ensureDirectMessagesCollection,
+		ensureDmMessagesCollection,
+		ensureKnocksCollection,
+		ensureInvitesCollection,
+		ensureInviteCodesCollection,
+		ensureHouseSettingsCollection,
+		ensureAuditLogCollection,
+		ensureHomeownerTransfersCollection,
+		ensureMessageReactionsCollection,
+		ensureMessageRevisionsCollection,
+		ensureAttachmentsCollection,
+		ensureUserBlocksCollection,
+		applyAPIRules,
+		createIndexes,
+		ensureSearchIndex,
+	} {
+		if err := ensure(app); err != nil {
+			app.Cleanup()
+			t.Fatalf("failed to set up collections: %v", err)
+		}
+	}
+
+	return app
+}
+
+// createTestUser saves a user with the given role and returns it with an auth token.
+func createTestUser(t testing.TB, app core.App, name, role string) (*core.Record, string) {
+	t.Helper()
+
+	col, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := core.NewRecord(col)
+	user.SetEmail(name + "@hearth.test")
+	user.SetPassword("correct-horse-battery")
+	user.Set("display_name", name)
+	user.Set("role", role)
+	if err := app.Save(user); err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+
+	token, err := user.NewAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// createTestRoom saves a room of the given type, delegated to the given keyholders.
+func createTestRoom(t testing.TB, app core.App, slug, roomType, ownerID string, keyholders ...string) *core.Record {
+	t.Helper()
+
+	col, err := app.FindCollectionByNameOrId("rooms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room := core.NewRecord(col)
+	room.Set("name", slug)
+	room.Set("slug", slug)
+	room.Set("owner", ownerID)
+	room.Set("type", roomType)
+	room.Set("max_participants", 10)
+	room.Set("livekit_room_name", "hearth-"+slug)
+	room.Set("keyholders", keyholders)
+	room.Set("visibility", roomVisibilityOpen)
+	if err := app.Save(room); err != nil {
+		t.Fatalf("failed to create room %s: %v", slug, err)
+	}
+	return room
+}
+
+// roomBody is a rooms create request body.
+func roomBody(slug, roomType, ownerID string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf(
+		`{"name":%q,"slug":%q,"type":%q,"owner":%q,"max_participants":10,"livekit_room_name":"hearth-%s"}`,
+		slug, slug, roomType, ownerID, slug,
+	))
+}
+
+func TestRoleRules(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindRoleHooks(app)
+
+	homeowner, homeownerToken := createTestUser(t, app, "home", "homeowner")
+	keyholder, keyholderToken := createTestUser(t, app, "keys", "keyholder")
+	member, memberToken := createTestUser(t, app, "member", "member")
+	_, bystanderToken := createTestUser(t, app, "bystander", "keyholder")
+
+	guest, guestToken := createTestUser(t, app, "guest", "member")
+	guest.Set("guest", true)
+	if err := app.Save(guest); err != nil {
+		t.Fatal(err)
+	}
+
+	den := createTestRoom(t, app, "the-den", "den", homeowner.Id)
+	delegated := createTestRoom(t, app, "delegated", "campfire", member.Id, keyholder.Id)
+	undelegated := createTestRoom(t, app, "undelegated", "campfire", member.Id)
+
+	messagesCol, _ := app.FindCollectionByNameOrId("messages")
+	newMessage := func(room *core.Record) *core.Record {
+		msg := core.NewRecord(messagesCol)
+		msg.Set("room", room.Id)
+		msg.Set("author", member.Id)
+		msg.Set("body", "hello")
+		msg.Set("type", "text")
+		msg.Set("expires_at", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	delegatedMsg := newMessage(delegated)
+	undelegatedMsg := newMessage(undelegated)
+
+	house, err := app.FindFirstRecordByFilter("house_settings", "id != ''")
+	if err != nil {
+		t.Fatalf("house_settings should be seeded: %v", err)
+	}
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		// Room creation
+		{
+			Name:            "member lights a campfire",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("member-fire", "campfire", member.Id),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"slug":"member-fire"`},
+		},
+		{
+			Name:            "member cannot build a den",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("member-den", "den", member.Id),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "keyholder builds a den",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("keys-den", "den", keyholder.Id),
+			Headers:         auth(keyholderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"slug":"keys-den"`},
+		},
+		{
+			Name:            "member cannot create a room for someone else",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("forged-fire", "campfire", homeowner.Id),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "guest cannot create rooms",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("guest-fire", "campfire", guest.Id),
+			Headers:         auth(guestToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+
+		// Room configuration
+		{
+			Name:            "keyholder configures any den",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + den.Id,
+			Body:            strings.NewReader(`{"description":"cozy"}`),
+			Headers:         auth(bystanderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"description":"cozy"`},
+		},
+		{
+			Name:            "keyholder cannot hand a den over",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + den.Id,
+			Body:            strings.NewReader(fmt.Sprintf(`{"owner":%q}`, keyholder.Id)),
+			Headers:         auth(keyholderToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "keyholder configures a delegated campfire",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + delegated.Id,
+			Body:            strings.NewReader(`{"description":"warm"}`),
+			Headers:         auth(keyholderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"description":"warm"`},
+		},
+		{
+			Name:            "keyholder cannot configure an undelegated campfire",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + undelegated.Id,
+			Body:            strings.NewReader(`{"description":"mine now"}`),
+			Headers:         auth(keyholderToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "member owner cannot turn a campfire into a den",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + undelegated.Id,
+			Body:            strings.NewReader(`{"type":"den"}`),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+
+		// Moderation
+		{
+			Name:           "keyholder removes a message in a delegated room",
+			Method:         http.MethodDelete,
+			URL:            "/api/collections/messages/records/" + delegatedMsg.Id,
+			Headers:        auth(keyholderToken),
+			ExpectedStatus: 204,
+		},
+		{
+			Name:            "keyholder cannot remove messages elsewhere",
+			Method:          http.MethodDelete,
+			URL:             "/api/collections/messages/records/" + undelegatedMsg.Id,
+			Headers:         auth(keyholderToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+
+		// Roles
+		{
+			Name:            "member cannot promote themselves",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/users/records/" + member.Id,
+			Body:            strings.NewReader(`{"role":"homeowner"}`),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"change your own role"},
+		},
+		{
+			Name:   "sign-up cannot pick a role",
+			Method: http.MethodPost,
+			URL:    "/api/collections/users/records",
+			Body: strings.NewReader(`{"email":"sneaky@hearth.test","password":"correct-horse-battery",` +
+				`"passwordConfirm":"correct-horse-battery","display_name":"sneaky","role":"homeowner"}`),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"role":"member"`},
+			NotExpectedContent: []string{`"role":"homeowner"`},
+		},
+
+		// House settings
+		{
+			Name:            "member cannot change house settings",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/house_settings/records/" + house.Id,
+			Body:            strings.NewReader(`{"members_create_campfires":false}`),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "homeowner closes campfire creation to members",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/house_settings/records/" + house.Id,
+			Body:            strings.NewReader(`{"members_create_campfires":false}`),
+			Headers:         auth(homeownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"members_create_campfires":false`},
+		},
+		{
+			Name:            "member cannot light a campfire once closed",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("late-fire", "campfire", member.Id),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "keyholder still lights campfires once closed",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/rooms/records",
+			Body:            roomBody("keys-fire", "campfire", keyholder.Id),
+			Headers:         auth(keyholderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"slug":"keys-fire"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+// createTestMember adds a room membership holding the given grants.
+func createTestMember(t testing.TB, app core.App, room, user *core.Record, role string, permissions ...string) *core.Record {
+	t.Helper()
+
+	col, err := app.FindCollectionByNameOrId("room_members")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := core.NewRecord(col)
+	member.Set("room", room.Id)
+	member.Set("user", user.Id)
+	member.Set("role", role)
+	member.Set("permissions", permissions)
+	if err := app.Save(member); err != nil {
+		t.Fatalf("failed to add %s to %s: %v", user.GetString("display_name"), room.GetString("slug"), err)
+	}
+	return member
+}
+
+func TestHasRoomPermission(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	friend, _ := createTestUser(t, app, "friend", "member")
+	plain, _ := createTestUser(t, app, "plain", "member")
+	homeowner, _ := createTestUser(t, app, "home", "homeowner")
+	outsider, _ := createTestUser(t, app, "outsider", "member")
+
+	guest, _ := createTestUser(t, app, "guest", "member")
+	guest.Set("guest", true)
+	if err := app.Save(guest); err != nil {
+		t.Fatal(err)
+	}
+
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+	createTestMember(t, app, room, owner, "owner")
+	createTestMember(t, app, room, friend, "member", permModerateMessages, permManageVoice)
+	createTestMember(t, app, room, plain, "member")
+	createTestMember(t, app, room, guest, "guest", permInvite)
+
+	tests := []struct {
+		name       string
+		user       *core.Record
+		permission string
+		allowed    bool
+	}{
+		{"owner holds every grant", owner, permConfigure, true},
+		{"homeowner holds every grant", homeowner, permManageMembers, true},
+		{"friend holds a granted permission", friend, permManageVoice, true},
+		{"friend lacks an ungranted permission", friend, permInvite, false},
+		{"plain member holds nothing", plain, permModerateMessages, false},
+		{"outsider holds nothing", outsider, permModerateMessages, false},
+		{"guests hold nothing", guest, permInvite, false},
+		{"nil user", nil, permInvite, false},
+	}
+	for _, tt := range tests {
+		if got := hasRoomPermission(app, room, tt.user, tt.permission); got != tt.allowed {
+			t.Errorf("%s: hasRoomPermission = %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+}
+
+func TestRoomPermissionRules(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	owner, ownerToken := createTestUser(t, app, "owner", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+	plain, plainToken := createTestUser(t, app, "plain", "member")
+	decorator, decoratorToken := createTestUser(t, app, "decorator", "member")
+	other, _ := createTestUser(t, app, "other", "member")
+
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+	ownerMembership := createTestMember(t, app, room, owner, "owner")
+	friendMembership := createTestMember(t, app, room, friend, "member", permModerateMessages, permManageMembers)
+	plainMembership := createTestMember(t, app, room, plain, "member")
+	createTestMember(t, app, room, decorator, "member", permConfigure)
+	otherMembership := createTestMember(t, app, room, other, "member")
+
+	messagesCol, _ := app.FindCollectionByNameOrId("messages")
+	newMessage := func() *core.Record {
+		msg := core.NewRecord(messagesCol)
+		msg.Set("room", room.Id)
+		msg.Set("author", other.Id)
+		msg.Set("body", "hello")
+		msg.Set("type", "text")
+		msg.Set("expires_at", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	msg := newMessage()
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		// moderate_messages
+		{
+			Name:            "member without the grant cannot remove messages",
+			Method:          http.MethodDelete,
+			URL:             "/api/collections/messages/records/" + msg.Id,
+			Headers:         auth(plainToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:           "moderator friend removes a message",
+			Method:         http.MethodDelete,
+			URL:            "/api/collections/messages/records/" + msg.Id,
+			Headers:        auth(friendToken),
+			ExpectedStatus: 204,
+		},
+
+		// configure
+		{
+			Name:            "member without the grant cannot configure the room",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + room.Id,
+			Body:            strings.NewReader(`{"description":"mine"}`),
+			Headers:         auth(plainToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "configure grant edits room settings",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + room.Id,
+			Body:            strings.NewReader(`{"description":"string lights"}`),
+			Headers:         auth(decoratorToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"description":"string lights"`},
+		},
+		{
+			Name:            "configure grant cannot hand the room over",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/rooms/records/" + room.Id,
+			Body:            strings.NewReader(fmt.Sprintf(`{"owner":%q}`, decorator.Id)),
+			Headers:         auth(decoratorToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+
+		// manage_members
+		{
+			Name:            "member without the grant cannot change memberships",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/room_members/records/" + otherMembership.Id,
+			Body:            strings.NewReader(`{"role":"guest"}`),
+			Headers:         auth(plainToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "manage_members changes a membership",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/room_members/records/" + otherMembership.Id,
+			Body:            strings.NewReader(`{"role":"guest"}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"role":"guest"`},
+		},
+		{
+			Name:            "manage_members cannot crown a new owner",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/room_members/records/" + otherMembership.Id,
+			Body:            strings.NewReader(`{"role":"owner"}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "manage_members cannot hand out grants",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/room_members/records/" + friendMembership.Id,
+			Body:            strings.NewReader(`{"permissions":["configure"]}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "manage_members cannot remove the owner",
+			Method:          http.MethodDelete,
+			URL:             "/api/collections/room_members/records/" + ownerMembership.Id,
+			Headers:         auth(friendToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:           "manage_members removes a member",
+			Method:         http.MethodDelete,
+			URL:            "/api/collections/room_members/records/" + otherMembership.Id,
+			Headers:        auth(friendToken),
+			ExpectedStatus: 204,
+		},
+		{
+			Name:            "owner hands out a grant",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/room_members/records/" + plainMembership.Id,
+			Body:            strings.NewReader(`{"permissions":["manage_voice"]}`),
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"permissions":["manage_voice"]`},
+		},
+		{
+			Name:            "unknown grants are rejected",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/room_members/records/" + plainMembership.Id,
+			Body:            strings.NewReader(`{"permissions":["own_everything"]}`),
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"permissions"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+func TestRoomVisibilityRules(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(inviteRoutes)
+
+	owner, ownerToken := createTestUser(t, app, "owner", "member")
+	insider, insiderToken := createTestUser(t, app, "insider", "member")
+	doorman, doormanToken := createTestUser(t, app, "doorman", "member")
+	wanderer, wandererToken := createTestUser(t, app, "wanderer", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+	_, homeownerToken := createTestUser(t, app, "home", "homeowner")
+
+	withVisibility := func(slug, visibility string) *core.Record {
+		room := createTestRoom(t, app, slug, "den", owner.Id)
+		room.Set("visibility", visibility)
+		if err := app.Save(room); err != nil {
+			t.Fatal(err)
+		}
+		return room
+	}
+	open := createTestRoom(t, app, "open-den", "den", owner.Id)
+	knockOnly := withVisibility("knock-den", roomVisibilityKnock)
+	private := withVisibility("private-den", roomVisibilityPrivate)
+
+	createTestMember(t, app, private, owner, "owner")
+	createTestMember(t, app, private, insider, "member")
+	createTestMember(t, app, private, doorman, "member", permManageMembers)
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	joinBody := func(room, user *core.Record, role string) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"room":%q,"user":%q,"role":%q}`, room.Id, user.Id, role))
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		// Listing
+		{
+			Name:               "non-members don't see private rooms",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/rooms/records",
+			Headers:            auth(wandererToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"slug":"open-den"`, `"slug":"knock-den"`, `"totalItems":2`},
+			NotExpectedContent: []string{`"slug":"private-den"`},
+		},
+		{
+			Name:            "non-members can't view a private room directly",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/rooms/records/" + private.Id,
+			Headers:         auth(wandererToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "or probe its slug through the invite list",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/invite/list?room_slug=private-den",
+			Headers:         auth(wandererToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"message":"Room not found."`},
+		},
+		{
+			Name:            "which answers the same as a slug that doesn't exist",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/invite/list?room_slug=no-such-den",
+			Headers:         auth(wandererToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"message":"Room not found."`},
+		},
+		{
+			Name:            "members list their private room's invites",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/invite/list?room_slug=private-den",
+			Headers:         auth(insiderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"items":[]`},
+		},
+		{
+			Name:               "or who belongs to them",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/room_members/records",
+			Headers:            auth(wandererToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"totalItems":0`},
+			NotExpectedContent: []string{private.Id, insider.Id},
+		},
+		{
+			Name:            "members see who else is in their private room",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/room_members/records",
+			Headers:         auth(insiderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":3`, private.Id, doorman.Id},
+		},
+		{
+			Name:            "members see their private room",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/rooms/records",
+			Headers:         auth(insiderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"slug":"private-den"`, `"totalItems":3`},
+		},
+		{
+			Name:            "the homeowner sees every room",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/rooms/records",
+			Headers:         auth(homeownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"slug":"private-den"`, `"totalItems":3`},
+		},
+
+		// Self-join
+		{
+			Name:            "anyone walks into an open room",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(open, wanderer, "member"),
+			Headers:         auth(wandererToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"role":"member"`},
+		},
+		{
+			Name:            "self-join can't claim ownership",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(open, friend, "owner"),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:   "self-join can't grant permissions",
+			Method: http.MethodPost,
+			URL:    "/api/collections/room_members/records",
+			Body: strings.NewReader(fmt.Sprintf(`{"room":%q,"user":%q,"role":"member","permissions":[%q,%q,%q,%q,%q]}`,
+				open.Id, friend.Id, permModerateMessages, permManageMembers, permManageVoice, permInvite, permConfigure)),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "or claim a voucher",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            strings.NewReader(fmt.Sprintf(`{"room":%q,"user":%q,"role":"member","vouched_by":%q}`, open.Id, friend.Id, owner.Id)),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "listed-but-knock rooms can't be self-joined",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(knockOnly, wanderer, "member"),
+			Headers:         auth(wandererToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "private rooms can't be self-joined",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(private, wanderer, "member"),
+			Headers:         auth(wandererToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+
+		// Owner actions
+		{
+			Name:            "members can't add others to a private room",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(private, wanderer, "member"),
+			Headers:         auth(insiderToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "manage_members adds someone to a private room",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(private, wanderer, "member"),
+			Headers:         auth(doormanToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"role":"member"`},
+		},
+		{
+			Name:            "the owner adds someone to a private room",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/room_members/records",
+			Body:            joinBody(private, friend, "member"),
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"role":"member"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+// =============================================================================
+// Admin API — roles, Homeowner transfer, audit log
+// =============================================================================
+
+func TestValidateRoleChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		current string
+		role    string
+		guest   bool
+		ok      bool
+	}{
+		{"promote member", "user2", "member", "keyholder", false, true},
+		{"demote keyholder", "user2", "keyholder", "member", false, true},
+		{"crown by role change", "user2", "member", "homeowner", false, false},
+		{"unknown role", "user2", "member", "admin", false, false},
+		{"own role", "home1", "homeowner", "member", false, false},
+		{"demote homeowner", "user2", "homeowner", "member", false, false},
+		{"promote guest", "user2", "member", "keyholder", true, false},
+	}
+
+	for _, tt := range tests {
+		err := validateRoleChange("home1", tt.target, tt.current, tt.role, tt.guest)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: validateRoleChange err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestTransferUsable(t *testing.T) {
+	now := time.Now()
+	if err := transferUsable(transferPending, now.Add(time.Hour), now); err != nil {
+		t.Errorf("pending transfer should be usable: %v", err)
+	}
+	if err := transferUsable(transferPending, now.Add(-time.Second), now); err != errTransferExpired {
+		t.Errorf("expired transfer: got %v, want %v", err, errTransferExpired)
+	}
+	if err := transferUsable(transferCancelled, now.Add(time.Hour), now); err != errTransferNotPending {
+		t.Errorf("cancelled transfer: got %v, want %v", err, errTransferNotPending)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(adminRoutes)
+
+	homeowner, homeownerToken := createTestUser(t, app, "home", "homeowner")
+	heir, heirToken := createTestUser(t, app, "heir", "member")
+	member, memberToken := createTestUser(t, app, "member", "member")
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+	roleOf := func(id string) string {
+		user, err := app.FindRecordById("users", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.GetString("role")
+	}
+
+	var transferID string
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "member cannot change roles",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/users/" + heir.Id + "/role",
+			Body:            strings.NewReader(`{"role":"keyholder"}`),
+			Headers:         auth(memberToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"Only the Homeowner"},
+		},
+		{
+			Name:            "homeowner promotes a keyholder",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/users/" + member.Id + "/role",
+			Body:            strings.NewReader(`{"role":"keyholder"}`),
+			Headers:         auth(homeownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"role":"keyholder"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if roleOf(member.Id) != "keyholder" {
+					t.Error("member should now be a keyholder")
+				}
+			},
+		},
+		{
+			Name:            "homeowner cannot crown by role change",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/users/" + heir.Id + "/role",
+			Body:            strings.NewReader(`{"role":"homeowner"}`),
+			Headers:         auth(homeownerToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"keyholder or member"},
+		},
+		{
+			Name:            "homeowner offers the House",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/transfer",
+			Body:            strings.NewReader(fmt.Sprintf(`{"user_id":%q}`, heir.Id)),
+			Headers:         auth(homeownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"pending"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				transfer, err := app.FindFirstRecordByFilter("homeowner_transfers", "status = 'pending'")
+				if err != nil {
+					t.Fatal(err)
+				}
+				transferID = transfer.Id
+				if roleOf(homeowner.Id) != "homeowner" || roleOf(heir.Id) != "member" {
+					t.Error("roles must not change until the recipient accepts")
+				}
+			},
+		},
+	}
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	// Second step, now that the transfer id is known
+	scenarios = []tests.ApiScenario{
+		{
+			Name:            "only the recipient can accept",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/transfer/" + transferID + "/accept",
+			Headers:         auth(memberToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"Transfer not found"},
+		},
+		{
+			Name:            "recipient accepts the House",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/transfer/" + transferID + "/accept",
+			Headers:         auth(heirToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"accepted"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if roleOf(heir.Id) != "homeowner" || roleOf(homeowner.Id) != "keyholder" {
+					t.Error("transfer should swap homeowner and keyholder roles")
+				}
+			},
+		},
+		{
+			Name:            "a transfer can't be accepted twice",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/admin/transfer/" + transferID + "/accept",
+			Headers:         auth(heirToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"no longer pending"},
+		},
+	}
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	for _, action := range []string{auditRoleChange, auditTransferStart, auditHomeownerTransfer} {
+		total, err := app.CountRecords("audit_log", dbx.HashExp{"action": action})
+		if err != nil || total != 1 {
+			t.Errorf("audit log should hold one %q entry, got %d (%v)", action, total, err)
+		}
+	}
+
+	// The backfill must not re-crown anyone after a legitimate transfer
+	if err := backfillHomeowner(app); err != nil {
+		t.Fatal(err)
+	}
+	if roleOf(homeowner.Id) != "keyholder" {
+		t.Error("backfill re-crowned the previous homeowner")
+	}
+}
+
+func TestHomeownerBackfillGuard(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	first, _ := createTestUser(t, app, "first", "member")
+	second, _ := createTestUser(t, app, "second", "member")
+
+	// Pre-roles install: the oldest user is crowned once
+	if err := backfillHomeowner(app); err != nil {
+		t.Fatal(err)
+	}
+	first, _ = app.FindRecordById("users", first.Id)
+	if first.GetString("role") != "homeowner" {
+		t.Fatalf("oldest user should be crowned, got %q", first.GetString("role"))
+	}
+
+	// The Homeowner leaves — nobody is crowned in their place
+	if err := app.Delete(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := backfillHomeowner(app); err != nil {
+		t.Fatal(err)
+	}
+	second, _ = app.FindRecordById("users", second.Id)
+	if second.GetString("role") != "member" {
+		t.Errorf("backfill re-crowned after the Homeowner was deleted: %q", second.GetString("role"))
+	}
+
+	crowns, _ := app.CountRecords("audit_log", dbx.HashExp{"action": auditHomeownerCrown})
+	if crowns != 1 {
+		t.Errorf("expected exactly one crown in the audit log, got %d", crowns)
+	}
+}
+
+// =============================================================================
+// Reactions
+// =============================================================================
+
+// createTestMessage saves a text message in room that expires after ttl.
+func createTestMessage(t testing.TB, app core.App, room, author *core.Record, ttl time.Duration) *core.Record {
+	t.Helper()
+
+	col, err := app.FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := core.NewRecord(col)
+	msg.Set("room", room.Id)
+	msg.Set("author", author.Id)
+	msg.Set("body", "hello")
+	msg.Set("type", "text")
+	msg.Set("expires_at", time.Now().Add(ttl).UTC().Format(time.RFC3339))
+	if err := app.Save(msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// createTestReaction saves a reaction without going through the API rules.
+func createTestReaction(t testing.TB, app core.App, msg, user *core.Record, emoji string) *core.Record {
+	t.Helper()
+
+	col, err := app.FindCollectionByNameOrId("message_reactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reaction := core.NewRecord(col)
+	reaction.Set("message", msg.Id)
+	reaction.Set("user", user.Id)
+	reaction.Set("emoji", emoji)
+	if err := app.Save(reaction); err != nil {
+		t.Fatal(err)
+	}
+	return reaction
+}
+
+func TestReactionRules(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindReactionHooks(app)
+
+	owner, ownerToken := createTestUser(t, app, "owner", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+	outsider, outsiderToken := createTestUser(t, app, "outsider", "member")
+
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+	createTestMember(t, app, room, owner, "owner")
+	createTestMember(t, app, room, friend, "member")
+
+	msg := createTestMessage(t, app, room, owner, time.Hour)
+	ownerFire := createTestReaction(t, app, msg, owner, "🔥")
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	reactBody := func(user *core.Record, emoji string) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"message":%q,"user":%q,"emoji":%q}`, msg.Id, user.Id, emoji))
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "clients can't seed reaction counts",
+			Method: http.MethodPost,
+			URL:    "/api/collections/messages/records",
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"room":%q,"author":%q,"body":"so popular","type":"text","expires_at":"2099-12-31 23:59:59.000Z","reaction_counts":{"🔥":999}}`,
+				room.Id, friend.Id,
+			)),
+			Headers:            auth(friendToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"reaction_counts":{}`},
+			NotExpectedContent: []string{"999"},
+		},
+		{
+			Name:            "members react",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/message_reactions/records",
+			Body:            reactBody(friend, "🔥"),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"emoji":"🔥"`},
+		},
+		{
+			Name:            "the same reaction twice is rejected",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/message_reactions/records",
+			Body:            reactBody(friend, "🔥"),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"emoji"`},
+		},
+		{
+			Name:            "counts are denormalized onto the message",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/messages/records/" + msg.Id,
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"reaction_counts":{"🔥":2}`},
+		},
+		{
+			Name:            "nobody reacts on someone else's behalf",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/message_reactions/records",
+			Body:            reactBody(owner, "👍"),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "non-members can't react",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/message_reactions/records",
+			Body:            reactBody(outsider, "👍"),
+			Headers:         auth(outsiderToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "non-members don't see reactions",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/message_reactions/records",
+			Headers:         auth(outsiderToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":0`},
+		},
+		{
+			Name:            "nobody takes back someone else's reaction",
+			Method:          http.MethodDelete,
+			URL:             "/api/collections/message_reactions/records/" + ownerFire.Id,
+			Headers:         auth(friendToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:           "members take back their own reaction",
+			Method:         http.MethodDelete,
+			URL:            "/api/collections/message_reactions/records/" + ownerFire.Id,
+			Headers:        auth(ownerToken),
+			ExpectedStatus: 204,
+		},
+		{
+			Name:            "counts follow the removal",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/messages/records/" + msg.Id,
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"reaction_counts":{"🔥":1}`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+func TestSweepExpiredMessagesTakesReactions(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+
+	expired := createTestMessage(t, app, room, owner, -time.Minute)
+	live := createTestMessage(t, app, room, owner, time.Minute)
+	createTestReaction(t, app, expired, owner, "🔥")
+	createTestReaction(t, app, live, owner, "🔥")
+
+	deleted, err := sweepExpiredMessages(app, time.Now())
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 expired message swept, got %d", deleted)
+	}
+
+	if _, err := app.FindRecordById("messages", live.Id); err != nil {
+		t.Errorf("unexpired message should survive the sweep: %v", err)
+	}
+	if n, _ := app.CountRecords("message_reactions", dbx.HashExp{"message": expired.Id}); n != 0 {
+		t.Errorf("reactions on a swept message should go with it, %d left", n)
+	}
+	if n, _ := app.CountRecords("message_reactions", dbx.HashExp{"message": live.Id}); n != 1 {
+		t.Errorf("reactions on a live message should stay, got %d", n)
+	}
+}
+
+// =============================================================================
+// Replies and threads
+// =============================================================================
+
+func TestReplyExcerpt(t *testing.T) {
+	long := strings.Repeat("ember ", 40)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"short body kept", "see you by the fire", "see you by the fire"},
+		{"whitespace collapsed to one line", "line one\n\n  line two", "line one line two"},
+		{"long body cut with an ellipsis", long, strings.TrimSpace(long[:replyExcerptLength]) + "…"},
+		{"cut on runes, not bytes", strings.Repeat("🔥", replyExcerptLength+5), strings.Repeat("🔥", replyExcerptLength) + "…"},
+	}
+	for _, tt := range tests {
+		if got := replyExcerpt(tt.body); got != tt.want {
+			t.Errorf("%s: replyExcerpt = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReplies(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindReplyHooks(app)
+	app.OnServe().BindFunc(replyRoutes)
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+	_, outsiderToken := createTestUser(t, app, "outsider", "member")
+
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+	room.Set("default_ttl", 3600)
+	if err := app.Save(room); err != nil {
+		t.Fatal(err)
+	}
+	elsewhere := createTestRoom(t, app, "attic", "campfire", owner.Id)
+	createTestMember(t, app, room, owner, "owner")
+	createTestMember(t, app, room, friend, "member")
+
+	parent := createTestMessage(t, app, room, owner, time.Hour)
+	parent.Set("author_name", "owner")
+	parent.Set("body", "who's bringing marshmallows?")
+	if err := app.Save(parent); err != nil {
+		t.Fatal(err)
+	}
+	stranger := createTestMessage(t, app, elsewhere, owner, time.Hour)
+
+	messagesCol, _ := app.FindCollectionByNameOrId("messages")
+	newReply := func(author, to *core.Record) *core.Record {
+		msg := core.NewRecord(messagesCol)
+		msg.Set("room", room.Id)
+		msg.Set("author", author.Id)
+		msg.Set("body", "me!")
+		msg.Set("type", "text")
+		msg.Set("reply_to", to.Id)
+		// A reply can't outlive the room's TTL, whatever the client sends
+		msg.Set("expires_at", "2099-12-31T23:59:59Z")
+		if err := app.Save(msg); err != nil {
+			t.Fatalf("reply should save: %v", err)
+		}
+		return msg
+	}
+
+	reply := newReply(friend, parent)
+	if expires := reply.GetDateTime("expires_at").Time(); expires.After(time.Now().Add(time.Hour + time.Minute)) {
+		t.Errorf("reply expires_at %v outlives the room TTL", expires)
+	}
+	if reply.GetString("reply_author_name") != "owner" || reply.GetString("reply_excerpt") != "who's bringing marshmallows?" {
+		t.Errorf("reply should snapshot its parent, got %q / %q",
+			reply.GetString("reply_author_name"), reply.GetString("reply_excerpt"))
+	}
+	nested := newReply(owner, reply)
+
+	dm, _, err := openDm(app, owner.Id, friend.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmMessagesCol, _ := app.FindCollectionByNameOrId("dm_messages")
+	dmMsg := core.NewRecord(dmMessagesCol)
+	dmMsg.Set("dm", dm.Id)
+	dmMsg.Set("author", friend.Id)
+	dmMsg.Set("body", "see you there")
+	if err := app.Save(dmMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "members reply with a snapshot of the parent",
+			Method: http.MethodPost,
+			URL:    "/api/collections/messages/records",
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"room":%q,"author":%q,"body":"me!","type":"text","reply_to":%q,"expires_at":"2099-12-31 23:59:59.000Z"}`,
+				room.Id, friend.Id, parent.Id,
+			)),
+			Headers:            auth(friendToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"reply_author_name":"owner"`, `"reply_excerpt":"who's bringing marshmallows?"`},
+			NotExpectedContent: []string{`"expires_at":"2099`},
+		},
+		{
+			Name:   "replies stay in their room",
+			Method: http.MethodPost,
+			URL:    "/api/collections/messages/records",
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"room":%q,"author":%q,"body":"me!","type":"text","reply_to":%q,"expires_at":"2099-12-31 23:59:59.000Z"}`,
+				room.Id, friend.Id, stranger.Id,
+			)),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"reply_to"`, "same room"},
+		},
+		{
+			Name:   "a quote can't be forged without a parent",
+			Method: http.MethodPost,
+			URL:    "/api/collections/messages/records",
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"room":%q,"author":%q,"body":"me!","type":"text","reply_author_name":"owner","reply_excerpt":"I owe everyone money","expires_at":"2099-12-31 23:59:59.000Z"}`,
+				room.Id, friend.Id,
+			)),
+			Headers:            auth(friendToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"reply_author_name":""`, `"reply_excerpt":""`},
+			NotExpectedContent: []string{"owe everyone"},
+		},
+		{
+			Name:   "nor in a DM",
+			Method: http.MethodPost,
+			URL:    "/api/collections/dm_messages/records",
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"dm":%q,"author":%q,"body":"me!","reply_author_name":"owner","reply_excerpt":"I owe everyone money"}`,
+				dm.Id, friend.Id,
+			)),
+			Headers:            auth(friendToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"reply_excerpt":""`},
+			NotExpectedContent: []string{"owe everyone"},
+		},
+		{
+			Name:            "or edited into a DM afterwards",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/dm_messages/records/" + dmMsg.Id,
+			Body:            strings.NewReader(`{"reply_author_name":"owner","reply_excerpt":"I owe everyone money"}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "DM authors can still fix their words",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/dm_messages/records/" + dmMsg.Id,
+			Body:            strings.NewReader(`{"body":"see you there!"}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"body":"see you there!"`},
+		},
+		{
+			Name:            "the thread holds every descendant",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/messages/" + parent.Id + "/thread",
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + parent.Id + `"`, `"id":"` + reply.Id + `"`, `"id":"` + nested.Id + `"`},
+		},
+		{
+			Name:            "non-members can't read the thread",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/messages/" + parent.Id + "/thread",
+			Headers:         auth(outsiderToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
+
+// =============================================================================
+// Search
+// =============================================================================
+
+func TestFtsMatchQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"marsh", `"marsh"*`},
+		{"toasted marsh", `"toasted" "marsh"*`},
+		{`say "hi" OR NOT`, `"say" """hi""" "OR" "NOT"*`},
+		{"col:umn*", `"col:umn*"*`},
+	}
+	for _, tt := range tests {
+		if got := ftsMatchQuery(tt.input); got != tt.want {
+			t.Errorf("ftsMatchQuery(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(searchRoutes)
+
+	alice, aliceToken := createTestUser(t, app, "alice", "member")
+	bob, bobToken := createTestUser(t, app, "bob", "member")
+	carol, _ := createTestUser(t, app, "carol", "member")
+
+	library := createTestRoom(t, app, "library", "den", alice.Id)
+	study := createTestRoom(t, app, "study", "den", alice.Id)
+	porch := createTestRoom(t, app, "porch", "campfire", alice.Id)
+	createTestMember(t, app, library, alice, "owner")
+	createTestMember(t, app, study, alice, "owner")
+	createTestMember(t, app, porch, alice, "owner")
+
+	say := func(room *core.Record, body string) *core.Record {
+		msg := createTestMessage(t, app, room, alice, time.Hour)
+		msg.Set("body", body)
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	denMsg := say(library, "the marshmallows are perfectly toasted")
+	studyMsg := say(study, "marshmallows for the study group")
+	campfireMsg := say(porch, "marshmallows burning on the porch")
+	deletedMsg := say(library, "marshmallows nobody should find")
+	sweptMsg := say(library, "marshmallows past their time")
+
+	dmsCol, _ := app.FindCollectionByNameOrId("direct_messages")
+	dmMessagesCol, _ := app.FindCollectionByNameOrId("dm_messages")
+	dmSay := func(from, to *core.Record, body string) *core.Record {
+		dm := core.NewRecord(dmsCol)
+		dm.Set("participant_a", from.Id)
+		dm.Set("participant_b", to.Id)
+		if err := app.Save(dm); err != nil {
+			t.Fatal(err)
+		}
+		msg := core.NewRecord(dmMessagesCol)
+		msg.Set("dm", dm.Id)
+		msg.Set("author", from.Id)
+		msg.Set("body", body)
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	aliceDm := dmSay(alice, carol, "bring marshmallows tonight")
+	bobDm := dmSay(bob, carol, "secret marshmallows stash")
+
+	// Deletion and GC both leave the index
+	if err := app.Delete(deletedMsg); err != nil {
+		t.Fatal(err)
+	}
+	sweptMsg.Set("expires_at", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	if err := app.Save(sweptMsg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sweepExpiredMessages(app, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "a query is required",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/search?q=%20",
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"required"},
+		},
+		{
+			Name:            "search requires auth",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/search?q=marsh",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:           "dens and own DMs are searchable, campfires never",
+			Method:         http.MethodGet,
+			URL:            "/api/hearth/search?q=marsh",
+			Headers:        auth(aliceToken),
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"id":"` + denMsg.Id + `"`, `"id":"` + studyMsg.Id + `"`, `"id":"` + aliceDm.Id + `"`,
+				`\u003cmark\u003emarshmallows\u003c/mark\u003e`, // <mark>marshmallows</mark>
+			},
+			NotExpectedContent: []string{campfireMsg.Id, deletedMsg.Id, sweptMsg.Id, bobDm.Id},
+		},
+		{
+			Name:               "room filter",
+			Method:             http.MethodGet,
+			URL:                "/api/hearth/search?scope=rooms&q=marsh&room=" + study.Id,
+			Headers:            auth(aliceToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"id":"` + studyMsg.Id + `"`, `"dm_messages":[]`},
+			NotExpectedContent: []string{denMsg.Id},
+		},
+		{
+			Name:            "date filter",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/search?q=marsh&to=2000-01-01",
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"messages":[]`, `"dm_messages":[]`},
+		},
+		{
+			Name:            "author filter",
+			Method:          http.MethodGet,
+			URL:             "/api/hearth/search?q=marsh&author=" + carol.Id,
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"messages":[]`, `"dm_messages":[]`},
+		},
+		{
+			Name:               "non-members don't find den history",
+			Method:             http.MethodGet,
+			URL:                "/api/hearth/search?q=marsh",
+			Headers:            auth(bobToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"messages":[]`, `"id":"` + bobDm.Id + `"`},
+			NotExpectedContent: []string{aliceDm.Id},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	// A den that becomes a campfire leaves the index
+	library.Set("type", "campfire")
+	if err := app.Save(library); err != nil {
+		t.Fatal(err)
+	}
+	hits, err := searchDenMessages(app, alice.Id, ftsMatchQuery("marsh"), searchFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].ID != studyMsg.Id {
+		t.Errorf("only the study den should remain searchable, got %+v", hits)
+	}
+}
+
+// =============================================================================
+// Edits and revisions
+// =============================================================================
+
+func TestMessageEditWindow(t *testing.T) {
+	for _, key := range []string{"EDIT_WINDOW_CAMPFIRE", "EDIT_WINDOW_DEN"} {
+		original := os.Getenv(key)
+		defer os.Setenv(key, original)
+	}
+
+	tests := []struct {
+		campfire, den string
+		roomType      string
+		want          time.Duration
+	}{
+		{"", "", "campfire", 5 * time.Minute},
+		{"", "", "den", time.Hour},
+		{"60", "", "campfire", time.Minute},
+		{"", "0", "den", 0}, // editing off
+		{"-1", "", "campfire", 5 * time.Minute},
+		{"", "9999999", "den", time.Hour}, // above 7-day cap
+		{"soon", "", "campfire", 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("EDIT_WINDOW_CAMPFIRE", tt.campfire)
+		os.Setenv("EDIT_WINDOW_DEN", tt.den)
+		if got := messageEditWindow(tt.roomType); got != tt.want {
+			t.Errorf("campfire=%q den=%q %s: got %v, want %v", tt.campfire, tt.den, tt.roomType, got, tt.want)
+		}
+	}
+}
+
+func TestMessageEdits(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindEditHooks(app)
+
+	owner, ownerToken := createTestUser(t, app, "owner", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+
+	den := createTestRoom(t, app, "library", "den", owner.Id)
+	elsewhere := createTestRoom(t, app, "attic", "den", owner.Id)
+	createTestMember(t, app, den, owner, "owner")
+	createTestMember(t, app, den, friend, "member")
+
+	msg := createTestMessage(t, app, den, friend, time.Hour)
+	msg.Set("body", "see you at eight")
+	if err := app.Save(msg); err != nil {
+		t.Fatal(err)
+	}
+	stale := createTestMessage(t, app, den, friend, time.Hour)
+	if _, err := app.DB().NewQuery("UPDATE messages SET created = {:created} WHERE id = {:id}").
+		Bind(dbx.Params{"created": types.NowDateTime().Add(-2 * time.Hour).String(), "id": stale.Id}).
+		Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "new messages aren't edited",
+			Method: http.MethodPost,
+			URL:    "/api/collections/messages/records",
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"room":%q,"author":%q,"body":"fresh","type":"text","expires_at":"2099-12-31 23:59:59.000Z","edited":true}`,
+				den.Id, friend.Id,
+			)),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"edited":false`},
+		},
+		{
+			Name:            "authors edit within the window",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/messages/records/" + msg.Id,
+			Body:            strings.NewReader(`{"body":"see you at nine"}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"body":"see you at nine"`, `"edited":true`},
+		},
+		{
+			Name:   "only the body changes",
+			Method: http.MethodPatch,
+			URL:    "/api/collections/messages/records/" + msg.Id,
+			Body: strings.NewReader(fmt.Sprintf(
+				`{"body":"see you at ten","room":%q,"expires_at":"2099-12-31 23:59:59.000Z","edited":false}`, elsewhere.Id,
+			)),
+			Headers:            auth(friendToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"room":"` + den.Id + `"`, `"edited":true`},
+			NotExpectedContent: []string{`"expires_at":"2099`},
+		},
+		{
+			Name:            "the window closes",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/messages/records/" + stale.Id,
+			Body:            strings.NewReader(`{"body":"too late"}`),
+			Headers:         auth(friendToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"no longer be edited"},
+		},
+		{
+			Name:            "nobody edits someone else's message",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/messages/records/" + msg.Id,
+			Body:            strings.NewReader(`{"body":"cancelled"}`),
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "room owners see prior versions",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/message_revisions/records?sort=created",
+			Headers:         auth(ownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":2`, `"body":"see you at eight"`, `"body":"see you at nine"`},
+		},
+		{
+			Name:            "members don't",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/message_revisions/records",
+			Headers:         auth(friendToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":0`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	// Campfire revisions fade with their message
+	porch := createTestRoom(t, app, "porch", "campfire", owner.Id)
+	embers := createTestMessage(t, app, porch, friend, -time.Minute)
+	if err := saveMessageRevision(app, embers.Id, "gone soon"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sweepExpiredMessages(app, time.Now()); err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if n, _ := app.CountRecords("message_revisions", dbx.HashExp{"message": embers.Id}); n != 0 {
+		t.Errorf("revisions of a swept message should go with it, %d left", n)
+	}
+}
+
+// =============================================================================
+// Attachments
+// =============================================================================
+
+// testJPEGWithEXIF encodes a small JPEG and splices in an APP1 segment carrying
+// a fake EXIF block with a GPS tag name, the way a phone camera would.
+func testJPEGWithEXIF(t testing.TB, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	payload := []byte("Exif\x00\x00GPSLatitude=51.5074N")
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, raw[:2]...) // SOI
+	out = append(out, segment...)
+	return append(out, raw[2:]...)
+}
+
+// attachmentUpload builds a multipart attachments create body.
+func attachmentUpload(t testing.TB, fields map[string]string, filename string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	mp := multipart.NewWriter(body)
+	for k, v := range fields {
+		mp.WriteField(k, v)
+	}
+	w, err := mp.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	if err := mp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, mp.FormDataContentType()
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	withEXIF := testJPEGWithEXIF(t, 64, 48)
+	if !bytes.Contains(withEXIF, []byte("GPSLatitude")) {
+		t.Fatal("fixture should carry the GPS tag")
+	}
+
+	upload, _ := filesystem.NewFileFromBytes(withEXIF, "beach.jpg")
+	cleaned, width, height, err := stripImageMetadata(upload)
+	if err != nil {
+		t.Fatalf("strip failed: %v", err)
+	}
+	data := readTestFile(t, cleaned)
+	if bytes.Contains(data, []byte("Exif")) || bytes.Contains(data, []byte("GPSLatitude")) {
+		t.Error("EXIF metadata should be stripped")
+	}
+	if width != 64 || height != 48 {
+		t.Errorf("dimensions: got %dx%d, want 64x48", width, height)
+	}
+
+	var png bytes.Buffer
+	if err := imaging.Encode(&png, image.NewRGBA(image.Rect(0, 0, 5000, 5000)), imaging.PNG); err != nil {
+		t.Fatal(err)
+	}
+	huge, _ := filesystem.NewFileFromBytes(png.Bytes(), "huge.png")
+	if _, _, _, err := stripImageMetadata(huge); err == nil {
+		t.Error("images over the pixel cap should be rejected")
+	}
+
+	animation := func(frames int) []byte {
+		anim := &gif.GIF{}
+		for i := 0; i < frames; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black, color.White}))
+			anim.Delay = append(anim.Delay, 10)
+		}
+		var out bytes.Buffer
+		if err := gif.EncodeAll(&out, anim); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	if frames, pixels, err := gifFrameSizes(animation(3)); err != nil || frames != 3 || pixels != 300 {
+		t.Errorf("expected 3 frames of 300 pixels, got %d / %d (err %v)", frames, pixels, err)
+	}
+	short, _ := filesystem.NewFileFromBytes(animation(3), "wave.gif")
+	if cleaned, _, _, err := stripImageMetadata(short); err != nil {
+		t.Errorf("a short animation should be accepted: %v", err)
+	} else if anim, err := gif.DecodeAll(bytes.NewReader(readTestFile(t, cleaned))); err != nil || len(anim.Image) != 3 {
+		t.Errorf("a short animation should keep its frames (err %v)", err)
+	}
+	long, _ := filesystem.NewFileFromBytes(animation(maxGIFFrames+1), "forever.gif")
+	if _, _, _, err := stripImageMetadata(long); err == nil {
+		t.Error("animations over the frame cap should be rejected")
+	}
+
+	text, _ := filesystem.NewFileFromBytes([]byte("just some notes"), "notes.txt")
+	passed, width, height, err := stripImageMetadata(text)
+	if err != nil || passed != text || width != 0 || height != 0 {
+		t.Errorf("non-images should pass through untouched, got %v %dx%d %v", passed, width, height, err)
+	}
+}
+
+// readTestFile reads back a filesystem.File's content.
+func readTestFile(t testing.TB, f *filesystem.File) []byte {
+	t.Helper()
+
+	r, err := f.Reader.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAttachments(t *testing.T) {
+	original := os.Getenv("ATTACHMENT_QUOTA_USER_MB")
+	defer os.Setenv("ATTACHMENT_QUOTA_USER_MB", original)
+
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindAttachmentHooks(app)
+
+	owner, ownerToken := createTestUser(t, app, "owner", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+	createTestMember(t, app, room, owner, "owner")
+	createTestMember(t, app, room, friend, "member")
+
+	msg := createTestMessage(t, app, room, friend, time.Hour)
+	photo := testJPEGWithEXIF(t, 32, 32)
+
+	upload := func(token string, uploader *core.Record, filename string, content []byte) (*bytes.Buffer, map[string]string) {
+		body, contentType := attachmentUpload(t, map[string]string{"message": msg.Id, "uploader": uploader.Id}, filename, content)
+		return body, map[string]string{"Authorization": token, "Content-Type": contentType}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	photoBody, photoHeaders := upload(friendToken, friend, "beach.jpg", photo)
+	ownerBody, ownerHeaders := upload(ownerToken, owner, "beach.jpg", photo)
+	htmlBody, htmlHeaders := upload(friendToken, friend, "page.html", []byte("<html><script>alert(1)</script></html>"))
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "authors attach images to their messages",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/attachments/records",
+			Body:            photoBody,
+			Headers:         photoHeaders,
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"width":32`, `"height":32`, `"size":`},
+		},
+		{
+			Name:            "nobody attaches to someone else's message",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/attachments/records",
+			Body:            ownerBody,
+			Headers:         ownerHeaders,
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "only allowlisted types",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/attachments/records",
+			Body:            htmlBody,
+			Headers:         htmlHeaders,
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"file"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	stored, err := app.FindFirstRecordByFilter("attachments", "message = {:msg}", dbx.Params{"msg": msg.Id})
+	if err != nil {
+		t.Fatalf("attachment should be stored: %v", err)
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	key := stored.BaseFilesPath() + "/" + stored.GetString("file")
+	r, err := fsys.GetReader(key)
+	if err != nil {
+		t.Fatalf("stored file should exist: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if bytes.Contains(data, []byte("GPSLatitude")) {
+		t.Error("stored file still carries GPS metadata")
+	}
+
+	// Quotas count what's already stored
+	os.Setenv("ATTACHMENT_QUOTA_USER_MB", "0")
+	quotaBody, quotaHeaders := upload(friendToken, friend, "beach.jpg", photo)
+	(&tests.ApiScenario{
+		Name:                  "uploads stop at the quota",
+		Method:                http.MethodPost,
+		URL:                   "/api/collections/attachments/records",
+		Body:                  quotaBody,
+		Headers:               quotaHeaders,
+		ExpectedStatus:        400,
+		ExpectedContent:       []string{"validation_attachment_user_quota"},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+	}).Test(t)
+
+	// The message GC takes campfire attachments out of storage with their message
+	if _, err := app.DB().NewQuery("UPDATE messages SET expires_at = {:past} WHERE id = {:id}").
+		Bind(dbx.Params{"past": types.NowDateTime().Add(-time.Minute).String(), "id": msg.Id}).
+		Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sweepExpiredMessages(app, time.Now()); err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if n, _ := app.CountRecords("attachments", dbx.HashExp{"message": msg.Id}); n != 0 {
+		t.Errorf("attachments of a swept message should go with it, %d left", n)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		exists, err := fsys.Exists(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("swept attachment's file should be deleted from storage")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// =============================================================================
+// Avatars
+// =============================================================================
+
+func TestSquareAvatar(t *testing.T) {
+	upload, _ := filesystem.NewFileFromBytes(testJPEGWithEXIF(t, 600, 300), "me.jpeg")
+	square, err := squareAvatar(upload)
+	if err != nil {
+		t.Fatalf("squareAvatar failed: %v", err)
+	}
+
+	data := readTestFile(t, square)
+	if bytes.Contains(data, []byte("Exif")) {
+		t.Error("avatar metadata should be stripped")
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != avatarSize || config.Height != avatarSize || format != "jpeg" {
+		t.Errorf("got %s %dx%d, want jpeg %dx%d", format, config.Width, config.Height, avatarSize, avatarSize)
+	}
+	if !strings.HasSuffix(square.OriginalName, ".jpg") {
+		t.Errorf("original name %q should end in .jpg", square.OriginalName)
+	}
+
+	text, _ := filesystem.NewFileFromBytes([]byte("not a picture"), "me.png")
+	if _, err := squareAvatar(text); err == nil {
+		t.Error("non-images should be rejected")
+	}
+}
+
+func TestAvatars(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindAvatarHooks(app)
+
+	user, token := createTestUser(t, app, "ember", "member")
+	_, homeownerToken := createTestUser(t, app, "owner", "homeowner")
+	user.Set("avatar_url", "https://tracker.example/me.png")
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := app.FindFirstRecordByFilter("house_settings", "id != ''")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := new(bytes.Buffer)
+	mp := multipart.NewWriter(body)
+	w, _ := mp.CreateFormFile("avatar", "me.jpg")
+	w.Write(testJPEGWithEXIF(t, 400, 300))
+	mp.Close()
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "users upload an avatar",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/users/records/" + user.Id,
+			Body:            body,
+			Headers:         map[string]string{"Authorization": token, "Content-Type": mp.FormDataContentType()},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"avatar":"me`, `.jpg"`},
+		},
+		{
+			Name:               "new Houses hide external avatars",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/users/records/" + user.Id,
+			Headers:            auth(token),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"avatar_url":""`},
+			NotExpectedContent: []string{"tracker.example"},
+		},
+		{
+			Name:            "and refuse new ones",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/users/records/" + user.Id,
+			Body:            strings.NewReader(`{"avatar_url":"https://elsewhere.example/me.png"}`),
+			Headers:         auth(token),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_external_avatars_off"},
+		},
+		{
+			Name:            "the Homeowner can allow them",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/house_settings/records/" + settings.Id,
+			Body:            strings.NewReader(`{"external_avatars":true}`),
+			Headers:         auth(homeownerToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"external_avatars":true`},
+		},
+		{
+			Name:            "then they show",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/users/records/" + user.Id,
+			Headers:         auth(token),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"tracker.example"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	user, err = app.FindRecordById("users", user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	r, err := fsys.GetReader(user.BaseFilesPath() + "/" + user.GetString("avatar"))
+	if err != nil {
+		t.Fatalf("avatar should be stored: %v", err)
+	}
+	defer r.Close()
+	config, _, err := image.DecodeConfig(r)
+	if err != nil || config.Width != avatarSize || config.Height != avatarSize {
+		t.Errorf("stored avatar should be %dx%d, got %dx%d (%v)", avatarSize, avatarSize, config.Width, config.Height, err)
+	}
+}
+
+// =============================================================================
+// Campfire extinction
+// =============================================================================
+
+func TestCampfireIdleTTL(t *testing.T) {
+	original := os.Getenv("CAMPFIRE_IDLE_TTL")
+	defer os.Setenv("CAMPFIRE_IDLE_TTL", original)
+
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", 24 * time.Hour},
+		{"3600", time.Hour},
+		{"60", 24 * time.Hour},       // below 5-minute floor
+		{"99999999", 24 * time.Hour}, // above 30-day cap
+		{"forever", 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("CAMPFIRE_IDLE_TTL", tt.env)
+		if got := getCampfireIdleTTL(); got != tt.want {
+			t.Errorf("CAMPFIRE_IDLE_TTL=%q: got %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestSweepIdleCampfires(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindCampfireHooks(app)
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	sitter, _ := createTestUser(t, app, "sitter", "member")
+
+	ashes := createTestRoom(t, app, "ashes", "campfire", owner.Id)
+	embers := createTestRoom(t, app, "embers", "campfire", owner.Id)
+	occupied := createTestRoom(t, app, "occupied", "campfire", owner.Id)
+	den := createTestRoom(t, app, "library", "den", owner.Id)
+	fresh := createTestRoom(t, app, "fresh", "campfire", owner.Id)
+	membership := createTestMember(t, app, ashes, owner, "owner")
+	createTestMessage(t, app, embers, owner, time.Hour)
+
+	longAgo := types.NowDateTime().Add(-48 * time.Hour).String()
+	for _, room := range []*core.Record{ashes, embers, occupied, den} {
+		if _, err := app.DB().NewQuery("UPDATE rooms SET last_activity = {:at} WHERE id = {:id}").
+			Bind(dbx.Params{"at": longAgo, "id": room.Id}).
+			Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	presence.Heartbeat(sitter.Id, occupied.Id, "sitter")
+	defer presence.Remove(sitter.Id)
+
+	removed, err := sweepIdleCampfires(app, time.Now())
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 campfire extinguished, got %d", removed)
+	}
+
+	if _, err := app.FindRecordById("rooms", ashes.Id); err == nil {
+		t.Error("a quiet, empty campfire should be extinguished")
+	}
+	if _, err := app.FindRecordById("room_members", membership.Id); err == nil {
+		t.Error("memberships should go with the campfire")
+	}
+	for _, kept := range []*core.Record{embers, occupied, den, fresh} {
+		if _, err := app.FindRecordById("rooms", kept.Id); err != nil {
+			t.Errorf("room %s should survive the sweep: %v", kept.GetString("slug"), err)
+		}
+	}
+
+	occupied, _ = app.FindRecordById("rooms", occupied.Id)
+	if occupied.GetDateTime("last_activity").String() == longAgo {
+		t.Error("a campfire with someone present should have its idle clock reset")
+	}
+
+	if n, _ := app.CountRecords("audit_log", dbx.HashExp{"action": auditCampfireExtinguish}); n != 1 {
+		t.Errorf("expected 1 extinction in the audit log, got %d", n)
+	}
+}
+
+// =============================================================================
+// Burn faster and burn after read
+// =============================================================================
+
+func TestMessageExpiresAt(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	campfire := core.NewRecord(core.NewBaseCollection("rooms"))
+	campfire.Set("default_ttl", 3600)
+	den := core.NewRecord(core.NewBaseCollection("rooms"))
+	den.Set("default_ttl", 0)
+
+	tests := []struct {
+		name      string
+		room      *core.Record
+		requested int
+		want      string
+	}{
+		{"campfire default", campfire, 0, "2026-01-01T13:00:00Z"},
+		{"burn faster", campfire, 60, "2026-01-01T12:01:00Z"},
+		{"never longer than the room", campfire, 7200, "2026-01-01T13:00:00Z"},
+		{"den default", den, 0, "2099-12-31T23:59:59Z"},
+		{"den message with a lifetime", den, 600, "2026-01-01T12:10:00Z"},
+	}
+	for _, tt := range tests {
+		if got := messageExpiresAt(tt.room, tt.requested, now); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBurnAfterRead(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(burnRoutes)
+
+	author, authorToken := createTestUser(t, app, "author", "member")
+	friend, friendToken := createTestUser(t, app, "friend", "member")
+	lurker, lurkerToken := createTestUser(t, app, "lurker", "member")
+	_, outsiderToken := createTestUser(t, app, "outsider", "member")
+
+	room := createTestRoom(t, app, "porch", "campfire", author.Id)
+	for _, u := range []*core.Record{author, friend, lurker} {
+		createTestMember(t, app, room, u, "member")
+	}
+
+	burning := func() *core.Record {
+		msg := createTestMessage(t, app, room, author, time.Hour)
+		msg.Set("burn_after_read", 30)
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	expiresAt := func(msg *core.Record) time.Time {
+		fresh, err := app.FindRecordById("messages", msg.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fresh.GetDateTime("expires_at").Time()
+	}
+
+	for _, u := range []*core.Record{author, friend, lurker} {
+		presence.Heartbeat(u.Id, room.Id, u.GetString("display_name"))
+		defer presence.Remove(u.Id)
+	}
+
+	msg := burning()
+	read := func(name, token string) tests.ApiScenario {
+		return tests.ApiScenario{
+			Name:            name,
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/messages/" + msg.Id + "/read",
+			Headers:         map[string]string{"Authorization": token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"ok":true`, `"expires_at":`},
+		}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		read("the author's own ack doesn't count", authorToken),
+		read("members ack", friendToken),
+		{
+			Name:            "non-members can't ack",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/messages/" + msg.Id + "/read",
+			Headers:         map[string]string{"Authorization": outsiderToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+	}
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	if time.Until(expiresAt(msg)) < 50*time.Minute {
+		t.Fatal("message shouldn't burn while someone present hasn't read it")
+	}
+
+	last := read("the last present member acks", lurkerToken)
+	last.TestAppFactory = factory
+	last.DisableTestAppCleanup = true
+	last.Test(t)
+
+	if left := time.Until(expiresAt(msg)); left > 31*time.Second || left < 0 {
+		t.Errorf("once everyone present has read it, it should burn in 30s, got %v", left)
+	}
+
+	// Someone leaving without reading completes the condition on the next GC pass
+	unread := burning()
+	readAcks.Ack(unread.Id, friend.Id)
+	if burned := sweepReadAcks(app, time.Now()); burned != 0 {
+		t.Errorf("nothing should burn while the lurker is still here, got %d", burned)
+	}
+	presence.Remove(lurker.Id)
+	if burned := sweepReadAcks(app, time.Now()); burned != 1 {
+		t.Errorf("expected the message to burn once the lurker left, got %d", burned)
+	}
+	if len(readAcks.Pending()) != 0 {
+		t.Errorf("burned messages should leave no read acks, got %v", readAcks.Pending())
+	}
+}
+
+func TestTTLPolicy(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindTTLPolicyHooks(app)
+
+	owner, memberToken := createTestUser(t, app, "owner", "member")
+	_, homeownerToken := createTestUser(t, app, "keeper", "homeowner")
+
+	campfire := createTestRoom(t, app, "blaze", "campfire", owner.Id)
+	if ttl := campfire.GetInt("default_ttl"); ttl != defaultTTLPolicy.CampfireDefault {
+		t.Errorf("a campfire without a TTL should get the House default, got %d", ttl)
+	}
+	den := createTestRoom(t, app, "library", "den", owner.Id)
+	if ttl := den.GetInt("default_ttl"); ttl != 0 {
+		t.Errorf("a den should keep its messages, got TTL %d", ttl)
+	}
+
+	long := createTestRoom(t, app, "long", "campfire", owner.Id)
+	long.Set("default_ttl", 7200)
+	if err := app.Save(long); err != nil {
+		t.Fatalf("a TTL within bounds should be accepted: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		room     *core.Record
+		ttl      int
+		expected string
+	}{
+		{"campfire under the minimum", campfire, 30, "validation_ttl_bounds"},
+		{"den with a TTL", den, 600, "validation_ttl_den"},
+	} {
+		room, err := app.FindRecordById("rooms", tc.room.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		room.Set("default_ttl", tc.ttl)
+		var errs validation.Errors
+		err = app.Save(room)
+		if !errors.As(err, &errs) || errs["default_ttl"] == nil || errs["default_ttl"].(validation.Error).Code() != tc.expected {
+			t.Errorf("%s: expected %s, got %v", tc.name, tc.expected, err)
+		}
+	}
+
+	switched, err := app.FindRecordById("rooms", den.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switched.Set("type", "campfire")
+	if err := app.Save(switched); err != nil {
+		t.Fatalf("a den becoming a campfire should take a TTL that fits: %v", err)
+	}
+	if ttl := switched.GetInt("default_ttl"); ttl != defaultTTLPolicy.CampfireDefault {
+		t.Errorf("expected the House default TTL after switching type, got %d", ttl)
+	}
+
+	settings, err := app.FindFirstRecordByFilter("house_settings", "id != ''")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "/api/collections/house_settings/records/" + settings.Id
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "members can read the policy",
+			Method:          http.MethodGet,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": memberToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"campfire_ttl_min":60`, `"campfire_ttl_max":86400`, `"dens_may_expire":false`},
+		},
+		{
+			Name:            "but not change it",
+			Method:          http.MethodPatch,
+			URL:             url,
+			Body:            strings.NewReader(`{"campfire_ttl_max":600}`),
+			Headers:         map[string]string{"Authorization": memberToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "the default must sit within the bounds",
+			Method:          http.MethodPatch,
+			URL:             url,
+			Body:            strings.NewReader(`{"campfire_ttl_max":1800}`),
+			Headers:         map[string]string{"Authorization": homeownerToken},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_ttl_policy_default"},
+		},
+		{
+			Name:            "the minimum can't pass the maximum",
+			Method:          http.MethodPatch,
+			URL:             url,
+			Body:            strings.NewReader(`{"campfire_ttl_min":3600,"campfire_ttl_max":600}`),
+			Headers:         map[string]string{"Authorization": homeownerToken},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_ttl_policy_min"},
+		},
+		{
+			Name:            "the Homeowner tightens the policy",
+			Method:          http.MethodPatch,
+			URL:             url,
+			Body:            strings.NewReader(`{"campfire_ttl_max":1800,"campfire_ttl_default":900}`),
+			Headers:         map[string]string{"Authorization": homeownerToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"campfire_ttl_max":1800`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	for _, tc := range []struct {
+		room *core.Record
+		ttl  int
+	}{
+		{campfire, 1800},
+		{long, 1800},
+		{switched, 1800},
+	} {
+		room, err := app.FindRecordById("rooms", tc.room.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := room.GetInt("default_ttl"); ttl != tc.ttl {
+			t.Errorf("room %s: expected TTL %d after tightening, got %d", room.GetString("slug"), tc.ttl, ttl)
+		}
+	}
+
+	if ttl := createTestRoom(t, app, "spark", "campfire", owner.Id).GetInt("default_ttl"); ttl != 900 {
+		t.Errorf("new campfires should get the new default, got %d", ttl)
+	}
+}
+
+func TestFormatDmTimer(t *testing.T) {
+	for seconds, expected := range map[int]string{
+		60:     "1 minute",
+		90:     "90 seconds",
+		300:    "5 minutes",
+		5400:   "90 minutes",
+		3600:   "1 hour",
+		86400:  "1 day",
+		604800: "7 days",
+	} {
+		if got := formatDmTimer(seconds); got != expected {
+			t.Errorf("formatDmTimer(%d) = %q, want %q", seconds, got, expected)
+		}
+	}
+}
+
+func TestDmTimers(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindDmTimerHooks(app)
+
+	alice, aliceToken := createTestUser(t, app, "alice", "member")
+	bob, bobToken := createTestUser(t, app, "bob", "member")
+	carol, carolToken := createTestUser(t, app, "carol", "member")
+
+	dmsCol, _ := app.FindCollectionByNameOrId("direct_messages")
+	dmMessagesCol, _ := app.FindCollectionByNameOrId("dm_messages")
+	dm := core.NewRecord(dmsCol)
+	dm.Set("participant_a", alice.Id)
+	dm.Set("participant_b", bob.Id)
+	if err := app.Save(dm); err != nil {
+		t.Fatal(err)
+	}
+	say := func(body string) *core.Record {
+		msg := core.NewRecord(dmMessagesCol)
+		msg.Set("dm", dm.Id)
+		msg.Set("author", alice.Id)
+		msg.Set("body", body)
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	before := say("this one stays")
+	if got := before.GetDateTime("expires_at").Time().Year(); got != 2099 {
+		t.Errorf("DM messages without a timer should be permanent, expires in %d", got)
+	}
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+	dmURL := "/api/collections/direct_messages/records/" + dm.Id
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "outsiders can't set the timer",
+			Method:          http.MethodPatch,
+			URL:             dmURL,
+			Body:            strings.NewReader(`{"disappear_after":3600}`),
+			Headers:         auth(carolToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "the timer has a floor",
+			Method:          http.MethodPatch,
+			URL:             dmURL,
+			Body:            strings.NewReader(`{"disappear_after":30}`),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_dm_timer_min"},
+		},
+		{
+			Name:               "either participant sets it, and only it",
+			Method:             http.MethodPatch,
+			URL:                dmURL,
+			Body:               strings.NewReader(fmt.Sprintf(`{"disappear_after":3600,"participant_b":%q}`, carol.Id)),
+			Headers:            auth(bobToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"disappear_after":3600`, `"participant_b":"` + bob.Id + `"`},
+			NotExpectedContent: []string{carol.Id},
+		},
+		{
+			Name:            "clients can't post system notices",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/dm_messages/records",
+			Body:            strings.NewReader(fmt.Sprintf(`{"dm":%q,"author":%q,"body":"alice left","type":"system"}`, dm.Id, bob.Id)),
+			Headers:         auth(bobToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "or move an expiry",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/dm_messages/records/" + before.Id,
+			Body:            strings.NewReader(`{"expires_at":"2000-01-01 00:00:00.000Z"}`),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	notice, err := app.FindFirstRecordByFilter("dm_messages", "dm = {:dm} && type = 'system'", dbx.Params{"dm": dm.Id})
+	if err != nil {
+		t.Fatalf("setting the timer should post a notice: %v", err)
+	}
+	if body := notice.GetString("body"); body != "bob set messages to disappear after 1 hour" {
+		t.Errorf("unexpected timer notice %q", body)
+	}
+
+	after := say("this one fades")
+	if left := time.Until(after.GetDateTime("expires_at").Time()); left < 59*time.Minute || left > time.Hour {
+		t.Errorf("expected the message to disappear in an hour, got %v", left)
+	}
+
+	(&tests.ApiScenario{
+		Name:                  "turning it off",
+		Method:                http.MethodPatch,
+		URL:                   dmURL,
+		Body:                  strings.NewReader(`{"disappear_after":0}`),
+		Headers:               auth(aliceToken),
+		ExpectedStatus:        200,
+		ExpectedContent:       []string{`"disappear_after":0`},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+	}).Test(t)
+	if n, _ := app.CountRecords("dm_messages", dbx.HashExp{"body": "alice turned off disappearing messages"}); n != 1 {
+		t.Error("turning the timer off should post a notice")
+	}
+
+	if _, err := sweepExpiredMessages(app, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, gone := range []*core.Record{after, notice} {
+		if _, err := app.FindRecordById("dm_messages", gone.Id); err == nil {
+			t.Errorf("%q should have disappeared", gone.GetString("body"))
+		}
+	}
+	if _, err := app.FindRecordById("dm_messages", before.Id); err != nil {
+		t.Errorf("messages from before the timer should stay: %v", err)
+	}
+}
+
+func TestOpenDm(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(dmRoutes)
+
+	alice, aliceToken := createTestUser(t, app, "alice", "member")
+	bob, bobToken := createTestUser(t, app, "bob", "member")
+	guest, guestToken := createTestUser(t, app, "guest", "member")
+	guest.Set("guest", true)
+	if err := app.Save(guest); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := dmParticipants(bob.Id, alice.Id)
+	open := func(userID string) io.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"user_id":%q}`, userID))
+	}
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "requires auth",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            open(alice.Id),
+			ExpectedStatus:  401,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "guests can't start DMs",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            open(alice.Id),
+			Headers:         auth(guestToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "nobody can message themselves",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            open(alice.Id),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"message yourself"},
+		},
+		{
+			Name:            "unknown users",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            open("nobody123456789"),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "opening a new DM orders the pair",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            open(alice.Id),
+			Headers:         auth(bobToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"created":true`, `"participant_a":"` + a + `"`, `"participant_b":"` + b + `"`},
+		},
+		{
+			Name:            "the other side gets the same DM",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            open(bob.Id),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"created":false`, `"participant_a":"` + a + `"`},
+		},
+		{
+			Name:            "clients can't create DMs directly",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/direct_messages/records",
+			Body:            strings.NewReader(fmt.Sprintf(`{"participant_a":%q,"participant_b":%q}`, b, a)),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"data":{}`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	if n, _ := app.CountRecords("direct_messages"); n != 1 {
+		t.Errorf("expected 1 DM, got %d", n)
+	}
+}
+
+func TestOpenDmRace(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+
+	alice, _ := createTestUser(t, app, "alice", "member")
+	bob, _ := createTestUser(t, app, "bob", "member")
+	carol, _ := createTestUser(t, app, "carol", "member")
+
+	// Both sides open the DM at once, from either end
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ids := map[string]bool{}
+	created := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from, to := alice.Id, bob.Id
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			dm, isNew, err := openDm(app, from, to)
+			if err != nil {
+				t.Errorf("open failed: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			ids[dm.Id] = true
+			if isNew {
+				created++
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(ids) != 1 || created != 1 {
+		t.Errorf("expected one DM created once, got %d DMs created %d times", len(ids), created)
+	}
+
+	// The other side's row lands between our lookup and our insert: the unique
+	// index turns ours away and we hand back theirs
+	var theirs *core.Record
+	racing := true
+	app.OnRecordCreate("direct_messages").BindFunc(func(e *core.RecordEvent) error {
+		if racing {
+			racing = false
+			theirs = core.NewRecord(e.Record.Collection())
+			theirs.Set("participant_a", e.Record.GetString("participant_a"))
+			theirs.Set("participant_b", e.Record.GetString("participant_b"))
+			if err := e.App.Save(theirs); err != nil {
+				return err
+			}
+		}
+		return e.Next()
+	})
+
+	dm, isNew, err := openDm(app, carol.Id, alice.Id)
+	if err != nil {
+		t.Fatalf("losing the race should still open the DM: %v", err)
+	}
+	if isNew || theirs == nil || dm.Id != theirs.Id {
+		t.Error("losing the race should return the row that won")
+	}
+	if n, _ := app.CountRecords("direct_messages"); n != 2 {
+		t.Errorf("expected 2 DMs, got %d", n)
+	}
+}
+
+func TestUserBlocks(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	app.OnServe().BindFunc(dmRoutes)
+	bindBlockHooks(app)
+
+	alice, aliceToken := createTestUser(t, app, "alice", "member")
+	bob, bobToken := createTestUser(t, app, "bob", "member")
+	carol, carolToken := createTestUser(t, app, "carol", "member")
+
+	// A DM from before the block
+	dm, _, err := openDm(app, alice.Id, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocksCol, _ := app.FindCollectionByNameOrId("user_blocks")
+	block := core.NewRecord(blocksCol)
+	block.Set("blocker", alice.Id)
+	block.Set("blocked", bob.Id)
+	if err := app.Save(block); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := func(token string) map[string]string {
+		return map[string]string{"Authorization": token}
+	}
+	factory := func(testing.TB) *tests.TestApp { return app }
+	say := func(userID string) io.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"dm":%q,"author":%q,"body":"hello?"}`, dm.Id, userID))
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "blocks can't be made for someone else",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/user_blocks/records",
+			Body:            strings.NewReader(fmt.Sprintf(`{"blocker":%q,"blocked":%q}`, bob.Id, carol.Id)),
+			Headers:         auth(carolToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "or against yourself",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/user_blocks/records",
+			Body:            strings.NewReader(fmt.Sprintf(`{"blocker":%q,"blocked":%q}`, carol.Id, carol.Id)),
+			Headers:         auth(carolToken),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "the blocker sees the block",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/user_blocks/records",
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":1`, block.Id},
+		},
+		{
+			Name:               "the blocked user doesn't",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/user_blocks/records",
+			Headers:            auth(bobToken),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"totalItems":0`},
+			NotExpectedContent: []string{block.Id},
+		},
+		{
+			Name:            "not even by id",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/user_blocks/records/" + block.Id,
+			Headers:         auth(bobToken),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "the blocked user can't open a DM",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            strings.NewReader(fmt.Sprintf(`{"user_id":%q}`, alice.Id)),
+			Headers:         auth(bobToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{dmUnavailableMessage},
+		},
+		{
+			Name:            "or write in one they already had",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/dm_messages/records",
+			Body:            say(bob.Id),
+			Headers:         auth(bobToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{dmUnavailableMessage},
+		},
+		{
+			Name:            "neither can the blocker",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/dm_messages/records",
+			Body:            say(alice.Id),
+			Headers:         auth(aliceToken),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{dmUnavailableMessage},
+		},
+		{
+			Name:            "others still can",
+			Method:          http.MethodPost,
+			URL:             "/api/hearth/dm/open",
+			Body:            strings.NewReader(fmt.Sprintf(`{"user_id":%q}`, alice.Id)),
+			Headers:         auth(carolToken),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"created":true`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = factory
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+
+	// Presence: bob no longer sees alice; carol still does
+	room := createTestRoom(t, app, "porch", "campfire", carol.Id)
+	for _, user := range []*core.Record{alice, bob, carol} {
+		presence.Heartbeat(user.Id, room.Id, user.GetString("display_name"))
+		defer presence.Remove(user.Id)
+	}
+	visible := func(viewer *core.Record) map[string]bool {
+		blockers, err := blockersOf(app, viewer.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[string]bool{}
+		for _, entry := range presence.GetRoomPresenceExcept(room.Id, blockers) {
+			seen[entry.UserID] = true
+		}
+		return seen
+	}
+	if seen := visible(bob); seen[alice.Id] || !seen[carol.Id] {
+		t.Errorf("the blocked user shouldn't see the blocker in presence, saw %v", seen)
+	}
+	if seen := visible(carol); !seen[alice.Id] || !seen[bob.Id] {
+		t.Errorf("blocks shouldn't hide anyone from third parties, saw %v", seen)
+	}
+
+	// Voice: shared until the blocker opts out, then refused to either side
+	for _, user := range []*core.Record{alice, bob, carol} {
+		if blocked, err := voiceBlocked(app, room.Id, user.Id); err != nil || blocked {
+			t.Errorf("%s: voice should stay shared without no_shared_voice (err %v)", user.GetString("display_name"), err)
+		}
+	}
+	block.Set("no_shared_voice", true)
+	if err := app.Save(block); err != nil {
+		t.Fatal(err)
+	}
+	for user, expected := range map[*core.Record]bool{alice: true, bob: true, carol: false} {
+		if blocked, err := voiceBlocked(app, room.Id, user.Id); err != nil || blocked != expected {
+			t.Errorf("%s: expected voice blocked = %v, got %v (err %v)", user.GetString("display_name"), expected, blocked, err)
+		}
+	}
+	presence.Remove(alice.Id)
+	if blocked, _ := voiceBlocked(app, room.Id, bob.Id); blocked {
+		t.Error("voice should open up once the blocker has left")
+	}
+}
+
+func TestSweepsUnhookReplies(t *testing.T) {
+	app := newTestHouse(t)
+	defer app.Cleanup()
+	bindReplyHooks(app)
+
+	owner, _ := createTestUser(t, app, "owner", "member")
+	friend, _ := createTestUser(t, app, "friend", "member")
+	guest, _ := createTestUser(t, app, "guest", "member")
+	guest.Set("guest", true)
+	guest.Set("guest_expires_at", types.NowDateTime().Add(-time.Minute))
+	if err := app.Save(guest); err != nil {
+		t.Fatal(err)
+	}
+	room := createTestRoom(t, app, "porch", "campfire", owner.Id)
+
+	messagesCol, _ := app.FindCollectionByNameOrId("messages")
+	dmMessagesCol, _ := app.FindCollectionByNameOrId("dm_messages")
+	reply := func(collection *core.Collection, parent *core.Record, set map[string]any) *core.Record {
+		msg := core.NewRecord(collection)
+		for k, v := range set {
+			msg.Set(k, v)
+		}
+		msg.Set("author", friend.Id)
+		msg.Set("body", "me!")
+		msg.Set("reply_to", parent.Id)
+		if err := app.Save(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	inRoom := map[string]any{"room": room.Id, "type": "text", "expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+
+	fadedParent := createTestMessage(t, app, room, owner, time.Hour)
+	guestParent := createTestMessage(t, app, room, guest, time.Hour)
+	replies := []*core.Record{
+		reply(messagesCol, fadedParent, inRoom),
+		reply(messagesCol, guestParent, inRoom),
+	}
+
+	dm, _, err := openDm(app, owner.Id, friend.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmParent := core.NewRecord(dmMessagesCol)
+	dmParent.Set("dm", dm.Id)
+	dmParent.Set("author", owner.Id)
+	dmParent.Set("body", "gone soon")
+	if err := app.Save(dmParent); err != nil {
+		t.Fatal(err)
+	}
+	replies = append(replies, reply(dmMessagesCol, dmParent, map[string]any{"dm": dm.Id}))
+
+	// The parents fade before their replies
+	for _, parent := range []*core.Record{fadedParent, dmParent} {
+		parent.Set("expires_at", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+		if err := app.Save(parent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sweepExpiredMessages(app, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sweepExpiredGuests(app); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range replies {
+		fresh, err := app.FindRecordById(r.Collection().Name, r.Id)
+		if err != nil {
+			t.Fatalf("reply should outlive its parent: %v", err)
+		}
+		if fresh.GetString("reply_to") != "" || fresh.GetString("reply_excerpt") == "" {
+			t.Errorf("a swept parent should leave reply_to empty and the snapshot, got %q / %q",
+				fresh.GetString("reply_to"), fresh.GetString("reply_excerpt"))
+		}
+		fresh.Set("body", "me! (edited)")
+		if err := app.Save(fresh); err != nil {
+			t.Errorf("a reply should still save once its parent is swept: %v", err)
+		}
+	}
+}
@@ -9,134 +9,333 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 // RegisterInvite sets up HMAC invite token generation and validation endpoints.
-// Invites are stateless — no DB writes on creation. Validation uses constant-time
-// comparison (hmac.Equal) to prevent timing side-channel attacks.
+// Invites are stateless by default — no DB writes on creation. "Tracked" invites
+// additionally get an invites record (max uses, use counter, revocation); see
+// invite_tracked.go. Short codes and QR codes are optional extras for sharing a
+// link off-screen; see invite_codes.go. Validation uses constant-time comparison
+// (hmac.Equal) to prevent timing side-channel attacks.
 func RegisterInvite(app *pocketbase.PocketBase) {
-	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
-		// POST /api/hearth/invite/generate
-		// Body: { "room_slug": "the-kitchen", "expires_in": 86400 }
-		// Returns: { "url": "https://..." }
-		// Requires auth + room membership
-		se.Router.POST("/api/hearth/invite/generate", func(e *core.RequestEvent) error {
-			info, _ := e.RequestInfo()
-
-			data := struct {
-				RoomSlug  string `json:"room_slug"`
-				ExpiresIn int64  `json:"expires_in"` // seconds from now
-			}{}
-			if err := e.BindBody(&data); err != nil {
-				return e.BadRequestError("Invalid request body", err)
-			}
-			if data.RoomSlug == "" {
-				return e.BadRequestError("room_slug is required", nil)
-			}
+	// Sweep expired short codes every hour — the signed link they point to is dead anyway
+	app.Cron().MustAdd("hearth_invite_code_sweep", "30 * * * *", func() {
+		res, err := app.DB().
+			NewQuery("DELETE FROM invite_codes WHERE expires_at <= {:now}").
+			Bind(dbx.Params{"now": types.NowDateTime().String()}).
+			Execute()
+		if err != nil {
+			app.Logger().Error("invite code sweep failed", "error", err)
+			return
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			app.Logger().Info("invite code sweep", "deleted", affected)
+		}
+	})
 
-			// Default expires_in to 24 hours
-			if data.ExpiresIn <= 0 {
-				data.ExpiresIn = 86400
-			}
-			// Cap at 7 days
-			if data.ExpiresIn > 604800 {
-				data.ExpiresIn = 604800
-			}
+	app.OnServe().BindFunc(inviteRoutes)
+}
 
-			// Verify room exists
-			room, err := e.App.FindFirstRecordByFilter(
-				"rooms",
-				"slug = {:slug}",
-				dbxParams("slug", data.RoomSlug),
-			)
-			if err != nil {
-				return e.NotFoundError("Room not found", err)
-			}
+// inviteRoutes registers the invite endpoints.
+func inviteRoutes(se *core.ServeEvent) error {
+	// POST /api/hearth/invite/generate
+	// Body: { "room_slug": "the-kitchen", "expires_in": 86400, "role": "guest",
+	//         "auto_approve": false, "tracked": false, "max_uses": 0,
+	//         "short_code": false, "qr": "" }
+	// Returns: { "url": "https://.../join?k=...", "short_code": "7K3M9QXA", "qr": "data:image/png;base64,..." }
+	// Requires auth + room membership. Granting the member role or skipping
+	// the Knock requires the invite grant (room moderators hold every grant).
+	se.Router.POST("/api/hearth/invite/generate", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
 
-			// Verify user is a member
-			_, err = e.App.FindFirstRecordByFilter(
-				"room_members",
-				"room = {:room} && user = {:user}",
-				dbxParams("room", room.Id, "user", info.Auth.Id),
-			)
-			if err != nil {
-				return e.ForbiddenError("Not a member of this room", nil)
-			}
+		data := struct {
+			RoomSlug    string `json:"room_slug"`
+			ExpiresIn   int64  `json:"expires_in"`   // seconds from now
+			Role        string `json:"role"`         // guest (default) | member
+			AutoApprove bool   `json:"auto_approve"` // skip the Knock
+			Tracked     bool   `json:"tracked"`      // back the link with an invites record
+			MaxUses     int    `json:"max_uses"`     // tracked only; 0 = unlimited
+			ShortCode   bool   `json:"short_code"`   // also issue a typeable 8-char code
+			QR          string `json:"qr"`           // "png" | "svg" — render the URL as a QR code
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+		if data.RoomSlug == "" {
+			return e.BadRequestError("room_slug is required", nil)
+		}
+		if data.Role == "" {
+			data.Role = inviteRoleGuest
+		}
+		if data.Role != inviteRoleGuest && data.Role != inviteRoleMember {
+			return e.BadRequestError("role must be guest or member", nil)
+		}
+		if data.QR != "" && data.QR != inviteQRPNG && data.QR != inviteQRSVG {
+			return e.BadRequestError("qr must be png or svg", nil)
+		}
 
-			// Generate invite
-			secret := getCurrentSecret()
-			if secret == nil {
-				return e.InternalServerError("Invite system not configured", nil)
-			}
+		// Default expires_in to 24 hours
+		if data.ExpiresIn <= 0 {
+			data.ExpiresIn = 86400
+		}
+		// Cap at 7 days
+		if data.ExpiresIn > 604800 {
+			data.ExpiresIn = 604800
+		}
 
-			expiresAt := time.Now().Unix() + data.ExpiresIn
-			domain := os.Getenv("HEARTH_DOMAIN")
-			if domain == "" {
-				domain = "localhost:8090"
-			}
+		// Guests can't vouch for anyone else
+		if info.Auth.GetBool("guest") {
+			return e.ForbiddenError("Guests cannot create invites", nil)
+		}
 
-			url := generateInviteURL(data.RoomSlug, expiresAt, secret, domain)
-
-			return e.JSON(200, map[string]string{
-				"url":        url,
-				"room_slug":  data.RoomSlug,
-				"expires_at": time.Unix(expiresAt, 0).UTC().Format(time.RFC3339),
-			})
-		}).Bind(apis.RequireAuth())
-
-		// POST /api/hearth/invite/validate
-		// Body: { "r": "the-kitchen", "t": "1735689600", "s": "f8a..." }
-		// Public endpoint (PoW may be required separately)
-		se.Router.POST("/api/hearth/invite/validate", func(e *core.RequestEvent) error {
-			data := struct {
-				RoomSlug  string `json:"r"`
-				Timestamp string `json:"t"`
-				Signature string `json:"s"`
-			}{}
-			if err := e.BindBody(&data); err != nil {
-				return e.BadRequestError("Invalid request body", err)
-			}
+		// Verify room exists
+		room, err := e.App.FindFirstRecordByFilter(
+			"rooms",
+			"slug = {:slug}",
+			dbxParams("slug", data.RoomSlug),
+		)
+		if err != nil {
+			return e.NotFoundError("Room not found", err)
+		}
+
+		// Verify user is a member
+		_, err = e.App.FindFirstRecordByFilter(
+			"room_members",
+			"room = {:room} && user = {:user}",
+			dbxParams("room", room.Id, "user", info.Auth.Id),
+		)
+		if err != nil {
+			return e.ForbiddenError("Not a member of this room", nil)
+		}
+
+		// Elevated grants need the authority to let someone in directly
+		if (data.Role == inviteRoleMember || data.AutoApprove) && !hasRoomPermission(e.App, room, info.Auth, permInvite) {
+			return e.ForbiddenError("You need the invite permission to grant membership or skip the Knock", nil)
+		}
+
+		// Generate invite
+		secret := getCurrentSecret()
+		if secret == nil {
+			return e.InternalServerError("Invite system not configured", nil)
+		}
+
+		expiresAt := time.Now().Unix() + data.ExpiresIn
+		domain := os.Getenv("HEARTH_DOMAIN")
+		if domain == "" {
+			domain = "localhost:8090"
+		}
+
+		claims := inviteClaims{
+			RoomSlug:    data.RoomSlug,
+			ExpiresAt:   expiresAt,
+			Role:        data.Role,
+			AutoApprove: data.AutoApprove,
+			InvitedBy:   info.Auth.Id,
+		}
 
-			timestamp, err := strconv.ParseInt(data.Timestamp, 10, 64)
+		// Stateless links stay zero-DB-hit; tracked ones get a backing record
+		if data.Tracked {
+			invite, err := createTrackedInvite(e.App, room.Id, info.Auth.Id, data.MaxUses, expiresAt)
 			if err != nil {
-				return e.BadRequestError("Invalid timestamp", err)
+				return e.BadRequestError("Failed to create invite", err)
 			}
+			claims.InviteID = invite.Id
+		}
 
-			secrets := getSecrets()
-			if len(secrets) == 0 {
-				return e.InternalServerError("Invite system not configured", nil)
-			}
+		token, err := encodeInviteToken(claims, secret)
+		if err != nil {
+			return e.InternalServerError("Failed to sign invite", err)
+		}
+
+		url := fmt.Sprintf("https://%s/join?k=%s", domain, token)
+		result := map[string]any{
+			"url":          url,
+			"room_slug":    data.RoomSlug,
+			"expires_at":   time.Unix(expiresAt, 0).UTC().Format(time.RFC3339),
+			"role":         claims.grantedRole(),
+			"auto_approve": claims.AutoApprove,
+			"invite_id":    claims.InviteID,
+		}
 
-			if !validateInvite(data.RoomSlug, timestamp, data.Signature, secrets) {
-				return e.BadRequestError("Invalid or expired invite", nil)
+		if data.ShortCode {
+			code, err := createInviteCode(e.App, room.Id, info.Auth.Id, token, expiresAt)
+			if err != nil {
+				return e.InternalServerError("Failed to create short code", err)
 			}
+			result["short_code"] = code
+		}
 
-			// Verify room exists
-			room, err := e.App.FindFirstRecordByFilter(
-				"rooms",
-				"slug = {:slug}",
-				dbxParams("slug", data.RoomSlug),
-			)
+		if data.QR != "" {
+			qr, err := renderInviteQR(url, data.QR)
 			if err != nil {
-				return e.NotFoundError("Room not found", nil)
+				return e.InternalServerError("Failed to render QR code", err)
 			}
+			result["qr"] = qr
+		}
 
-			return e.JSON(200, map[string]any{
-				"valid":     true,
-				"room_id":   room.Id,
-				"room_slug": data.RoomSlug,
-				"room_name": room.GetString("name"),
-			})
-		})
+		return e.JSON(200, result)
+	}).Bind(apis.RequireAuth())
+
+	// POST /api/hearth/invite/validate
+	// Body: { "k": "<v2 token>" } or v1 { "r": "the-kitchen", "t": "1735689600", "s": "f8a...", "i": "" }
+	// Public endpoint (PoW may be required separately). Does not spend a use.
+	se.Router.POST("/api/hearth/invite/validate", func(e *core.RequestEvent) error {
+		data := inviteParams{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+
+		if len(getSecrets()) == 0 {
+			return e.InternalServerError("Invite system not configured", nil)
+		}
+
+		claims, err := checkInvite(e.App, data)
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		// Verify room exists
+		room, err := e.App.FindFirstRecordByFilter(
+			"rooms",
+			"slug = {:slug}",
+			dbxParams("slug", claims.RoomSlug),
+		)
+		if err != nil {
+			return e.NotFoundError("Room not found", nil)
+		}
 
-		return se.Next()
+		return e.JSON(200, inviteSummary(room, claims))
 	})
+
+	// GET /api/hearth/invite/redeem/{code}
+	// Resolves a short code to its signed invite. Public, rate limited like validate.
+	// Returns the validate response plus "k" (the token) for the knock/sign-up step.
+	// Does not spend a use.
+	se.Router.GET("/api/hearth/invite/redeem/{code}", func(e *core.RequestEvent) error {
+		token, err := findInviteCode(e.App, e.Request.PathValue("code"))
+		if err != nil {
+			return e.NotFoundError("Invalid or expired invite code", nil)
+		}
+
+		claims, err := checkInvite(e.App, inviteParams{Token: token})
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		room, err := e.App.FindFirstRecordByFilter(
+			"rooms",
+			"slug = {:slug}",
+			dbxParams("slug", claims.RoomSlug),
+		)
+		if err != nil {
+			return e.NotFoundError("Room not found", nil)
+		}
+
+		result := inviteSummary(room, claims)
+		result["k"] = token
+		return e.JSON(200, result)
+	})
+
+	// GET /api/hearth/invite/list?room_slug=the-kitchen
+	// Tracked invites for a room. Room owners and the Homeowner see all of them;
+	// everyone else sees only the invites they created.
+	se.Router.GET("/api/hearth/invite/list", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		room, err := e.App.FindFirstRecordByFilter(
+			"rooms",
+			"slug = {:slug}",
+			dbxParams("slug", e.Request.URL.Query().Get("room_slug")),
+		)
+		if err != nil {
+			return e.NotFoundError("Room not found", nil)
+		}
+
+		// A private room the caller can't see answers like one that doesn't exist
+		canView, err := e.App.CanAccessRecord(room, info, room.Collection().ViewRule)
+		if !canView {
+			return e.NotFoundError("Room not found", err)
+		}
+
+		filter := "room = {:room}"
+		if !canManageInvites(room.GetString("owner"), info.Auth.Id, info.Auth.GetString("role")) {
+			filter += " && created_by = {:user}"
+		}
+
+		invites, err := e.App.FindRecordsByFilter(
+			"invites",
+			filter,
+			"-created",
+			200,
+			0,
+			dbxParams("room", room.Id, "user", info.Auth.Id),
+		)
+		if err != nil {
+			return e.InternalServerError("Failed to list invites", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"items": invites,
+		})
+	}).Bind(apis.RequireAuth())
+
+	// POST /api/hearth/invite/{id}/revoke
+	// Revokes one tracked invite without touching any other link in the House.
+	se.Router.POST("/api/hearth/invite/{id}/revoke", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		invite, err := e.App.FindRecordById("invites", e.Request.PathValue("id"))
+		if err != nil {
+			return e.NotFoundError("Invite not found", nil)
+		}
+
+		room, err := e.App.FindRecordById("rooms", invite.GetString("room"))
+		if err != nil {
+			return e.NotFoundError("Room not found", nil)
+		}
+
+		if invite.GetString("created_by") != info.Auth.Id &&
+			!canManageInvites(room.GetString("owner"), info.Auth.Id, info.Auth.GetString("role")) {
+			return e.ForbiddenError("Only the invite's creator or the room owner can revoke it", nil)
+		}
+
+		invite.Set("revoked", true)
+		if err := e.App.Save(invite); err != nil {
+			return e.BadRequestError("Failed to revoke invite", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"invite_id": invite.Id,
+			"revoked":   true,
+		})
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
 }
 
-// generateInviteURL creates a signed invite URL.
+// inviteSummary describes a verified invite for the join screen.
+func inviteSummary(room *core.Record, claims *inviteClaims) map[string]any {
+	return map[string]any{
+		"valid":        true,
+		"room_id":      room.Id,
+		"room_slug":    claims.RoomSlug,
+		"room_name":    room.GetString("name"),
+		"version":      claims.Version,
+		"role":         claims.grantedRole(),
+		"auto_approve": claims.AutoApprove,
+		"invited_by":   claims.InvitedBy,
+		"expires_at":   time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		"tracked":      claims.InviteID != "",
+	}
+}
+
+// generateInviteURL creates a signed v1 invite URL (no claims). New invites use
+// v2 tokens (see invite_claims.go); v1 links remain valid until they expire.
 func generateInviteURL(roomSlug string, expiresAt int64, secret []byte, domain string) string {
 	payload := roomSlug + "." + strconv.FormatInt(expiresAt, 10)
 	mac := hmac.New(sha256.New, secret)
@@ -154,7 +353,22 @@ func validateInvite(roomSlug string, timestamp int64, signature string, secrets
 	}
 
 	payload := roomSlug + "." + strconv.FormatInt(timestamp, 10)
+	return inviteSignatureValid(payload, signature, secrets)
+}
+
+// validateTrackedInvite checks the signature of a v1 tracked invite (payload includes
+// the record id). It does not check the record state — see checkInvite.
+func validateTrackedInvite(roomSlug string, timestamp int64, inviteID, signature string, secrets [][]byte) bool {
+	if time.Now().Unix() > timestamp || inviteID == "" {
+		return false
+	}
+
+	payload := roomSlug + "." + strconv.FormatInt(timestamp, 10) + "." + inviteID
+	return inviteSignatureValid(payload, signature, secrets)
+}
 
+// inviteSignatureValid compares a hex signature against the payload's HMAC under each secret.
+func inviteSignatureValid(payload, signature string, secrets [][]byte) bool {
 	for _, secret := range secrets {
 		mac := hmac.New(sha256.New, secret)
 		mac.Write([]byte(payload))
@@ -174,35 +388,48 @@ func validateInvite(roomSlug string, timestamp int64, signature string, secrets
 	return false
 }
 
-// getCurrentSecret returns the current HMAC secret from env.
+// getCurrentSecret returns the key new invites are signed with: the stored key set
+// (see invite_keys.go), else HMAC_SECRET_CURRENT.
 func getCurrentSecret() []byte {
-	s := os.Getenv("HMAC_SECRET_CURRENT")
-	if s == "" {
-		return nil
-	}
-	decoded, err := hex.DecodeString(s)
-	if err != nil {
-		return []byte(s) // fallback to raw string if not hex
+	if set := inviteKeys.current(); set != nil {
+		if secrets := set.secrets(time.Now()); len(secrets) > 0 {
+			return secrets[0]
+		}
 	}
-	return decoded
+	return getEnvSecret("HMAC_SECRET_CURRENT")
 }
 
-// getSecrets returns both current and old secrets for rotation support.
+// getSecrets returns every key that verifies invites (current first) for rotation support:
+// the stored key set, else HMAC_SECRET_CURRENT and HMAC_SECRET_OLD.
 func getSecrets() [][]byte {
+	if set := inviteKeys.current(); set != nil {
+		if secrets := set.secrets(time.Now()); len(secrets) > 0 {
+			return secrets
+		}
+	}
+
 	var secrets [][]byte
 
-	if current := getCurrentSecret(); current != nil {
+	if current := getEnvSecret("HMAC_SECRET_CURRENT"); current != nil {
 		secrets = append(secrets, current)
 	}
 
-	if old := os.Getenv("HMAC_SECRET_OLD"); old != "" {
-		decoded, err := hex.DecodeString(old)
-		if err != nil {
-			secrets = append(secrets, []byte(old))
-		} else {
-			secrets = append(secrets, decoded)
-		}
+	if old := getEnvSecret("HMAC_SECRET_OLD"); old != nil {
+		secrets = append(secrets, old)
 	}
 
 	return secrets
 }
+
+// getEnvSecret reads a hex-encoded secret from env, or nil if unset.
+func getEnvSecret(name string) []byte {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return []byte(s) // fallback to raw string if not hex
+	}
+	return decoded
+}
@@ -0,0 +1,105 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// inviteTokenVersion is the current signed-payload format. v1 links (?r=&t=&s=)
+// carry no claims and are still accepted by checkInvite.
+const inviteTokenVersion = 2
+
+// Membership roles an invite can grant.
+const (
+	inviteRoleGuest  = "guest"
+	inviteRoleMember = "member"
+)
+
+// inviteClaims is what a verified invite grants. v2 tokens sign all of it;
+// v1 links imply the defaults (guest role, no auto-approve, no inviter).
+type inviteClaims struct {
+	Version     int    `json:"v"`
+	RoomSlug    string `json:"r"`
+	ExpiresAt   int64  `json:"e"`
+	Role        string `json:"ro,omitempty"` // guest (default) | member
+	AutoApprove bool   `json:"a,omitempty"`  // skip the Knock
+	InvitedBy   string `json:"by,omitempty"` // user id recorded as vouched_by
+	InviteID    string `json:"i,omitempty"`  // tracked invites only
+}
+
+// grantedRole returns the membership role the invite grants, defaulting to guest.
+func (c *inviteClaims) grantedRole() string {
+	if c.Role == inviteRoleMember {
+		return inviteRoleMember
+	}
+	return inviteRoleGuest
+}
+
+// encodeInviteToken signs claims into a compact, URL-safe token:
+// base64url(json claims) + "." + base64url(HMAC-SHA256).
+func encodeInviteToken(claims inviteClaims, secret []byte) (string, error) {
+	claims.Version = inviteTokenVersion
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeInviteToken verifies a v2 token against each secret (for key rotation)
+// and returns its claims. The signature is checked before the payload is parsed.
+func decodeInviteToken(token string, secrets [][]byte) (*inviteClaims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInviteInvalid
+	}
+
+	provided, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, errInviteInvalid
+	}
+
+	valid := false
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(payload))
+		// hmac.Equal uses constant-time comparison — safe against timing attacks
+		if hmac.Equal(mac.Sum(nil), provided) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, errInviteInvalid
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInviteInvalid
+	}
+
+	claims := &inviteClaims{}
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return nil, errInviteInvalid
+	}
+
+	if claims.Version != inviteTokenVersion || claims.RoomSlug == "" {
+		return nil, errors.New("Unsupported invite version")
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, errInviteInvalid
+	}
+
+	return claims, nil
+}
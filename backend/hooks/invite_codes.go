@@ -0,0 +1,152 @@
+package hooks
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/skip2/go-qrcode"
+)
+
+// Short codes use Crockford's base32 alphabet: no I, L, O or U, so a code read
+// out loud or copied off a TV screen survives the usual mix-ups.
+const (
+	inviteCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	inviteCodeLength   = 8 // 40 bits
+)
+
+// QR output formats for /api/hearth/invite/generate.
+const (
+	inviteQRPNG = "png"
+	inviteQRSVG = "svg"
+)
+
+// inviteQRSize is the PNG edge length in pixels — crisp on a phone, scannable off a TV.
+const inviteQRSize = 256
+
+// newInviteCode returns a random 8-character base32 short code.
+func newInviteCode() (string, error) {
+	b := make([]byte, 5) // 5 bytes = 40 bits = 8 base32 chars
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("crypto/rand failed: %w", err)
+	}
+
+	bits := uint64(b[0])<<32 | uint64(b[1])<<24 | uint64(b[2])<<16 | uint64(b[3])<<8 | uint64(b[4])
+	code := make([]byte, inviteCodeLength)
+	for i := inviteCodeLength - 1; i >= 0; i-- {
+		code[i] = inviteCodeAlphabet[bits&31]
+		bits >>= 5
+	}
+	return string(code), nil
+}
+
+// normalizeInviteCode canonicalizes a typed code: case-insensitive, dashes and
+// spaces ignored, I/L read as 1 and O as 0. Returns "" if it can't be a code.
+func normalizeInviteCode(s string) string {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer("-", "", " ", "", "I", "1", "L", "1", "O", "0").Replace(s)
+
+	if len(s) != inviteCodeLength {
+		return ""
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(inviteCodeAlphabet, c) {
+			return ""
+		}
+	}
+	return s
+}
+
+// createInviteCode stores a short code pointing at a signed invite token.
+// Retries on the (unlikely) collision with an existing code.
+func createInviteCode(app core.App, roomID, creatorID, token string, expiresAt int64) (string, error) {
+	col, err := app.FindCollectionByNameOrId("invite_codes")
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err := newInviteCode()
+		if err != nil {
+			return "", err
+		}
+
+		record := core.NewRecord(col)
+		record.Set("code", code)
+		record.Set("token", token)
+		record.Set("room", roomID)
+		record.Set("created_by", creatorID)
+		record.Set("expires_at", time.Unix(expiresAt, 0).UTC().Format(time.RFC3339))
+
+		if err = app.Save(record); err == nil {
+			return code, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to allocate a unique invite code")
+}
+
+// findInviteCode resolves a short code to its signed token, ignoring expired codes.
+func findInviteCode(app core.App, code string) (string, error) {
+	code = normalizeInviteCode(code)
+	if code == "" {
+		return "", errInviteInvalid
+	}
+
+	record, err := app.FindFirstRecordByFilter(
+		"invite_codes",
+		"code = {:code} && expires_at > {:now}",
+		dbxParams("code", code, "now", types.NowDateTime().String()),
+	)
+	if err != nil {
+		return "", errInviteInvalid
+	}
+	return record.GetString("token"), nil
+}
+
+// renderInviteQR encodes an invite URL as a QR code data URI in the given format.
+func renderInviteQR(url, format string) (string, error) {
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case inviteQRPNG:
+		png, err := qr.PNG(inviteQRSize)
+		if err != nil {
+			return "", err
+		}
+		return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+	case inviteQRSVG:
+		svg := qrBitmapSVG(qr.Bitmap())
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg)), nil
+	default:
+		return "", fmt.Errorf("unsupported QR format %q", format)
+	}
+}
+
+// qrBitmapSVG renders a QR bitmap (quiet zone included) as a scalable SVG:
+// one path, one unit square per dark module.
+func qrBitmapSVG(bitmap [][]bool) string {
+	size := len(bitmap)
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	return fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+			`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size, size, path.String(),
+	)
+}
@@ -0,0 +1,303 @@
+package hooks
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cobra"
+)
+
+// inviteKeysParam is the _params row holding the invite signing key set.
+const inviteKeysParam = "hearth_invite_keys"
+
+// inviteKeysRefresh is how often a running server re-reads the key set, so a
+// rotation done from the CLI takes effect without a restart.
+const inviteKeysRefresh = 30 * time.Second
+
+// inviteKeySet is the stored invite signing keys. The previous key keeps
+// verifying links until PreviousUntil, then is dropped.
+type inviteKeySet struct {
+	Current       string `json:"current"` // hex
+	RotatedAt     int64  `json:"rotated_at"`
+	Previous      string `json:"previous,omitempty"` // hex
+	PreviousUntil int64  `json:"previous_until,omitempty"`
+}
+
+// secrets returns the keys that currently verify invites, newest first.
+func (s *inviteKeySet) secrets(now time.Time) [][]byte {
+	var secrets [][]byte
+	if current, err := hex.DecodeString(s.Current); err == nil && len(current) > 0 {
+		secrets = append(secrets, current)
+	}
+	if s.Previous != "" && now.Unix() < s.PreviousUntil {
+		if previous, err := hex.DecodeString(s.Previous); err == nil {
+			secrets = append(secrets, previous)
+		}
+	}
+	return secrets
+}
+
+// inviteKeyStore caches the stored key set for getSecrets/getCurrentSecret.
+// With no app attached (tests, or before bootstrap) it holds nothing and
+// callers fall back to HMAC_SECRET_CURRENT / HMAC_SECRET_OLD.
+type inviteKeyStore struct {
+	mu       sync.Mutex
+	app      core.App
+	set      *inviteKeySet
+	loadedAt time.Time
+}
+
+var inviteKeys = &inviteKeyStore{}
+
+// attach points the store at the app's database and forces a reload.
+func (s *inviteKeyStore) attach(app core.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.app = app
+	s.loadedAt = time.Time{}
+}
+
+// current returns the cached key set, re-reading it once it's older than inviteKeysRefresh.
+func (s *inviteKeyStore) current() *inviteKeySet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.app == nil {
+		return nil
+	}
+
+	if time.Since(s.loadedAt) >= inviteKeysRefresh {
+		set, err := loadInviteKeySet(s.app)
+		if err != nil {
+			// Keep serving the last good set rather than locking everyone out
+			s.app.Logger().Error("failed to load invite keys", "error", err)
+		} else {
+			s.set = set
+		}
+		s.loadedAt = time.Now()
+	}
+
+	return s.set
+}
+
+// RegisterInviteKeys attaches the stored invite key set to getSecrets and prunes
+// expired previous keys. Keys are rotated with `hearth secrets rotate-invite`.
+func RegisterInviteKeys(app *pocketbase.PocketBase) {
+	app.OnBootstrap().BindFunc(func(e *core.BootstrapEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		inviteKeys.attach(e.App)
+		return nil
+	})
+
+	// Drop the previous key from storage once its grace window has passed
+	app.Cron().MustAdd("hearth_invite_key_prune", "15 * * * *", func() {
+		pruned, err := pruneInviteKeys(app, time.Now())
+		if err != nil {
+			app.Logger().Error("invite key prune failed", "error", err)
+			return
+		}
+		if pruned {
+			app.Logger().Info("invite key prune", "dropped", "previous")
+		}
+	})
+}
+
+// NewSecretsCommand returns the `secrets` command group for the PocketBase CLI.
+func NewSecretsCommand(app core.App) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manages Hearth signing secrets",
+	}
+
+	var grace time.Duration
+	rotate := &cobra.Command{
+		Use:          "rotate-invite",
+		Short:        "Generates a new invite signing key; the old one keeps working for the grace window",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if grace < 0 {
+				return errors.New("--grace must not be negative")
+			}
+
+			set, err := rotateInviteKey(app, grace, time.Now())
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "New invite signing key is active.")
+			if set.Previous != "" {
+				fmt.Fprintf(out, "Previous key accepted until %s.\n", time.Unix(set.PreviousUntil, 0).UTC().Format(time.RFC3339))
+			}
+			if inviteKeysEncryptionKey(app) == "" {
+				fmt.Fprintln(out, "Warning: PB_ENCRYPTION_KEY is not set — keys are stored unencrypted.")
+			}
+			return nil
+		},
+	}
+	rotate.Flags().DurationVar(&grace, "grace", getInviteKeyGrace(), "how long the previous key keeps verifying invites")
+
+	command.AddCommand(rotate)
+	return command
+}
+
+// rotateInviteKey generates a new current key and demotes the old one to previous.
+// The first rotation seeds "old" from HMAC_SECRET_CURRENT so env-signed links survive it.
+func rotateInviteKey(app core.App, grace time.Duration, now time.Time) (*inviteKeySet, error) {
+	set, err := loadInviteKeySet(app)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := ""
+	if set != nil {
+		previous = set.Current
+	} else if env := getEnvSecret("HMAC_SECRET_CURRENT"); env != nil {
+		previous = hex.EncodeToString(env)
+	}
+
+	current, err := generateRandomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &inviteKeySet{
+		Current:   current,
+		RotatedAt: now.Unix(),
+	}
+	if previous != "" && grace > 0 {
+		next.Previous = previous
+		next.PreviousUntil = now.Add(grace).Unix()
+	}
+
+	if err := saveInviteKeySet(app, next); err != nil {
+		return nil, err
+	}
+	inviteKeys.attach(app)
+
+	return next, nil
+}
+
+// pruneInviteKeys removes an expired previous key from storage.
+func pruneInviteKeys(app core.App, now time.Time) (bool, error) {
+	set, err := loadInviteKeySet(app)
+	if err != nil || set == nil || set.Previous == "" || now.Unix() < set.PreviousUntil {
+		return false, err
+	}
+
+	set.Previous = ""
+	set.PreviousUntil = 0
+	if err := saveInviteKeySet(app, set); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// loadInviteKeySet reads the stored key set, or nil if none was ever rotated in.
+func loadInviteKeySet(app core.App) (*inviteKeySet, error) {
+	param := &core.Param{}
+	err := app.ModelQuery(param).Model(inviteKeysParam, param)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw := []byte(param.Value)
+
+	// Encrypted sets are stored as a JSON string of the ciphertext
+	var cipherText string
+	if json.Unmarshal(raw, &cipherText) == nil {
+		key := inviteKeysEncryptionKey(app)
+		if key == "" {
+			return nil, errors.New("invite keys are encrypted but PB_ENCRYPTION_KEY is not set")
+		}
+		if raw, err = security.Decrypt(cipherText, key); err != nil {
+			return nil, err
+		}
+	}
+
+	set := &inviteKeySet{}
+	if err := json.Unmarshal(raw, set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// saveInviteKeySet upserts the key set, encrypted with the app encryption key when one is set.
+func saveInviteKeySet(app core.App, set *inviteKeySet) error {
+	value, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+
+	if key := inviteKeysEncryptionKey(app); key != "" {
+		cipherText, err := security.Encrypt(value, key)
+		if err != nil {
+			return err
+		}
+		if value, err = json.Marshal(cipherText); err != nil {
+			return err
+		}
+	}
+
+	param := &core.Param{}
+	if err := app.ModelQuery(param).Model(inviteKeysParam, param); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		param.Id = inviteKeysParam
+		param.Created = types.NowDateTime()
+		param.MarkAsNew()
+	}
+	param.Updated = types.NowDateTime()
+	param.Value = types.JSONRaw(value)
+
+	return app.Save(param)
+}
+
+// inviteKeysEncryptionKey returns the AES key for the stored key set: the app's
+// --encryptionEnv variable, else PB_ENCRYPTION_KEY. A 64-char hex value (as made by
+// `openssl rand -hex 32`) is decoded to its 32 raw bytes.
+func inviteKeysEncryptionKey(app core.App) string {
+	name := app.EncryptionEnv()
+	if name == "" {
+		name = "PB_ENCRYPTION_KEY"
+	}
+
+	key := os.Getenv(name)
+	if len(key) == 64 {
+		if decoded, err := hex.DecodeString(key); err == nil {
+			return string(decoded)
+		}
+	}
+	return key
+}
+
+// getInviteKeyGrace reads INVITE_KEY_GRACE (seconds) or returns the default of 7 days —
+// the longest an invite can live, so no outstanding link dies early. Range: 0 to 30 days.
+func getInviteKeyGrace() time.Duration {
+	s := os.Getenv("INVITE_KEY_GRACE")
+	if s == "" {
+		return 7 * 24 * time.Hour
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 0 || secs > 2592000 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(secs) * time.Second
+}
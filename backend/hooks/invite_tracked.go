@@ -0,0 +1,164 @@
+package hooks
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// inviteParams are the query parameters of an invite link: ?k= for v2 tokens,
+// or the v1 form ?r=&t=&s= plus &i= for tracked invites.
+type inviteParams struct {
+	Token     string `json:"k"` // v2
+	RoomSlug  string `json:"r"`
+	Timestamp string `json:"t"`
+	Signature string `json:"s"`
+	InviteID  string `json:"i"` // v1 tracked invites only
+}
+
+var (
+	errInviteInvalid   = errors.New("Invalid or expired invite")
+	errInviteRevoked   = errors.New("This invite has been revoked")
+	errInviteExhausted = errors.New("This invite has been used up")
+)
+
+// checkInvite verifies an invite link and returns what it grants: first the
+// signature (v2 token or v1 params), then — for tracked invites only — the backing
+// record's state. Stateless links never touch the DB.
+func checkInvite(app core.App, p inviteParams) (*inviteClaims, error) {
+	secrets := getSecrets()
+	if len(secrets) == 0 {
+		return nil, errInviteInvalid
+	}
+
+	claims, err := verifyInviteSignature(p, secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.InviteID == "" {
+		return claims, nil
+	}
+
+	invite, err := app.FindRecordById("invites", claims.InviteID)
+	if err != nil {
+		return nil, errInviteInvalid
+	}
+
+	if err := inviteRecordUsable(invite.GetBool("revoked"), invite.GetInt("uses"), invite.GetInt("max_uses")); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifyInviteSignature checks an invite's signature in whichever format it arrived
+// and normalizes it into claims. v1 links get the default (guest) grant.
+func verifyInviteSignature(p inviteParams, secrets [][]byte) (*inviteClaims, error) {
+	if p.Token != "" {
+		return decodeInviteToken(p.Token, secrets)
+	}
+
+	timestamp, err := strconv.ParseInt(p.Timestamp, 10, 64)
+	if err != nil {
+		return nil, errInviteInvalid
+	}
+
+	if p.InviteID == "" {
+		if !validateInvite(p.RoomSlug, timestamp, p.Signature, secrets) {
+			return nil, errInviteInvalid
+		}
+	} else if !validateTrackedInvite(p.RoomSlug, timestamp, p.InviteID, p.Signature, secrets) {
+		return nil, errInviteInvalid
+	}
+
+	return &inviteClaims{
+		Version:   1,
+		RoomSlug:  p.RoomSlug,
+		ExpiresAt: timestamp,
+		Role:      inviteRoleGuest,
+		InviteID:  p.InviteID,
+	}, nil
+}
+
+// redeemInvite checks an invite and, if tracked, spends one use. The increment is a
+// single conditional UPDATE so concurrent redemptions can't overshoot max_uses.
+func redeemInvite(app core.App, p inviteParams) (*inviteClaims, error) {
+	claims, err := checkInvite(app, p)
+	if err != nil {
+		return nil, err
+	}
+	if claims.InviteID == "" {
+		return claims, nil
+	}
+
+	res, err := app.DB().NewQuery(`
+		UPDATE invites SET uses = uses + 1
+		WHERE id = {:id} AND revoked = FALSE AND (max_uses = 0 OR uses < max_uses)
+	`).Bind(dbxParams("id", claims.InviteID)).Execute()
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, errInviteExhausted
+	}
+
+	return claims, nil
+}
+
+// unredeemInvite gives back the use redeemInvite spent on a tracked invite, for
+// when whatever the invite was redeemed for didn't go through.
+func unredeemInvite(app core.App, claims *inviteClaims) error {
+	if claims == nil || claims.InviteID == "" {
+		return nil
+	}
+
+	_, err := app.DB().NewQuery(`
+		UPDATE invites SET uses = uses - 1
+		WHERE id = {:id} AND uses > 0
+	`).Bind(dbxParams("id", claims.InviteID)).Execute()
+	return err
+}
+
+// inviteRecordUsable applies the tracked-invite state rules.
+func inviteRecordUsable(revoked bool, uses, maxUses int) error {
+	if revoked {
+		return errInviteRevoked
+	}
+	if maxUses > 0 && uses >= maxUses {
+		return errInviteExhausted
+	}
+	return nil
+}
+
+// createTrackedInvite creates the invites record backing a tracked link.
+func createTrackedInvite(app core.App, roomID, creatorID string, maxUses int, expiresAt int64) (*core.Record, error) {
+	col, err := app.FindCollectionByNameOrId("invites")
+	if err != nil {
+		return nil, err
+	}
+
+	if maxUses < 0 {
+		maxUses = 0
+	}
+
+	invite := core.NewRecord(col)
+	invite.Set("room", roomID)
+	invite.Set("created_by", creatorID)
+	invite.Set("max_uses", maxUses)
+	invite.Set("uses", 0)
+	invite.Set("revoked", false)
+	invite.Set("expires_at", time.Unix(expiresAt, 0).UTC().Format(time.RFC3339))
+
+	if err := app.Save(invite); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// canManageInvites reports whether a user may list and revoke every tracked invite
+// for a room: the room owner or the Homeowner.
+func canManageInvites(roomOwnerID, userID, userRole string) bool {
+	return userID != "" && (userID == roomOwnerID || userRole == "homeowner")
+}
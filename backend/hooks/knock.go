@@ -0,0 +1,368 @@
+package hooks
+
+import (
+	"crypto/subtle"
+	"os"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Knock lifecycle states.
+const (
+	knockPending  = "pending"
+	knockApproved = "approved"
+	knockDenied   = "denied"
+)
+
+// knockRetention is how long answered (or abandoned) knocks are kept before the sweep.
+const knockRetention = 24 * time.Hour
+
+// RegisterKnock sets up The Knock: a guest presents a valid invite, knocks with a
+// display name and note, and a room moderator (see canModerateRoom) or a member
+// holding the manage_members grant lets them in or turns them away. Hosts see new
+// knocks through the realtime API on the knocks collection; guests poll their knock
+// with the secret returned on creation, and an approved anonymous knock hands back
+// a guest session (see guest.go).
+func RegisterKnock(app *pocketbase.PocketBase) {
+	// Sweep old knocks every hour — a knock is a doorstep moment, not a record
+	app.Cron().MustAdd("hearth_knock_sweep", "0 * * * *", func() {
+		cutoff := types.NowDateTime().Add(-knockRetention).String()
+
+		res, err := app.DB().
+			NewQuery("DELETE FROM knocks WHERE created <= {:cutoff}").
+			Bind(dbx.Params{"cutoff": cutoff}).
+			Execute()
+		if err != nil {
+			app.Logger().Error("knock sweep failed", "error", err)
+			return
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			app.Logger().Info("knock sweep", "deleted", affected)
+		}
+	})
+
+	app.OnServe().BindFunc(knockRoutes)
+}
+
+// knockRoutes registers the Knock endpoints.
+func knockRoutes(se *core.ServeEvent) error {
+	// POST /api/hearth/knock
+	// Body: { "k": "<invite token>", "display_name": "Sarah", "note": "hi!" } (v1 r/t/s/i also accepted)
+	// Returns: { "knock_id": "...", "secret": "...", "status": "pending" }
+	// Public endpoint. If the caller is authenticated, the knock is tied to their account.
+	// An auto-approve invite lets the guest straight in, vouched for by the inviter.
+	se.Router.POST("/api/hearth/knock", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		data := struct {
+			inviteParams
+			DisplayName string `json:"display_name"`
+			Note        string `json:"note"`
+			PowToken    string `json:"pow_token"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("Invalid request body", err)
+		}
+		if data.DisplayName == "" {
+			return e.BadRequestError("display_name is required", nil)
+		}
+
+		if len(getSecrets()) == 0 {
+			return e.InternalServerError("Invite system not configured", nil)
+		}
+
+		claims, err := checkInvite(e.App, data.inviteParams)
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		knocked := false
+
+		// Optional: knocks spend a PoW token just like sign-ups, and get it back
+		// if the knock then doesn't go through
+		if knockRequiresPoW() {
+			tokenExpiresAt, ok := powChallenges.spendToken(data.PowToken)
+			if !ok {
+				return e.ForbiddenError("A valid proof-of-work token is required", nil)
+			}
+			defer func() {
+				if !knocked {
+					powChallenges.issueToken(data.PowToken, tokenExpiresAt)
+				}
+			}()
+		}
+
+		room, err := e.App.FindFirstRecordByFilter(
+			"rooms",
+			"slug = {:slug}",
+			dbxParams("slug", claims.RoomSlug),
+		)
+		if err != nil {
+			return e.NotFoundError("Room not found", nil)
+		}
+
+		knocksCol, err := e.App.FindCollectionByNameOrId("knocks")
+		if err != nil {
+			return e.InternalServerError("Knock system not configured", err)
+		}
+
+		secret, err := generateRandomHex(32)
+		if err != nil {
+			return e.InternalServerError("Failed to generate knock secret", err)
+		}
+
+		knock := core.NewRecord(knocksCol)
+		knock.Set("room", room.Id)
+		knock.Set("display_name", SanitizeText(data.DisplayName))
+		knock.Set("note", SanitizeText(data.Note))
+		knock.Set("status", knockPending)
+		knock.Set("grant_role", claims.grantedRole())
+		knock.Set("secret", secret)
+
+		if info.Auth != nil && info.Auth.Collection().Name == "users" {
+			if info.Auth.GetBool("guest") {
+				return e.ForbiddenError("Guests can only visit the room they were let into", nil)
+			}
+
+			// Already a member? No need to knock.
+			if _, err := e.App.FindFirstRecordByFilter(
+				"room_members",
+				"room = {:room} && user = {:user}",
+				dbxParams("room", room.Id, "user", info.Auth.Id),
+			); err == nil {
+				return e.BadRequestError("Already a member of this room", nil)
+			}
+			knock.Set("user", info.Auth.Id)
+		}
+
+		// Spend a use of a tracked invite only once the knock is otherwise good to go
+		redeemed, err := redeemInvite(e.App, data.inviteParams)
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		err = e.App.RunInTransaction(func(txApp core.App) error {
+			if err := txApp.Save(knock); err != nil {
+				return err
+			}
+
+			// The door opens on its own only while the inviter still belongs to the
+			// room and may still skip the Knock
+			if !claims.AutoApprove || !isRoomMember(txApp, room.Id, claims.InvitedBy) {
+				return nil
+			}
+			inviter, err := txApp.FindRecordById("users", claims.InvitedBy)
+			if err != nil || !hasRoomPermission(txApp, room, inviter, permInvite) {
+				return nil
+			}
+			return approveKnock(txApp, knock, room, claims.InvitedBy)
+		})
+		if err != nil {
+			// Nobody got in, so the invite keeps its use
+			if err := unredeemInvite(e.App, redeemed); err != nil {
+				e.App.Logger().Error("failed to refund invite use", "error", err, "invite", redeemed.InviteID)
+			}
+			return e.BadRequestError("Failed to knock", err)
+		}
+		knocked = true
+
+		return e.JSON(200, map[string]any{
+			"knock_id":  knock.Id,
+			"secret":    secret,
+			"status":    knock.GetString("status"),
+			"room_name": room.GetString("name"),
+		})
+	})
+
+	// GET /api/hearth/knock/{id}?secret=...
+	// Guest-side status poll. The secret returned on creation proves ownership of the knock.
+	se.Router.GET("/api/hearth/knock/{id}", func(e *core.RequestEvent) error {
+		knock, err := e.App.FindRecordById("knocks", e.Request.PathValue("id"))
+		if err != nil {
+			return e.NotFoundError("Knock not found", nil)
+		}
+
+		if !knockSecretMatches(knock.GetString("secret"), e.Request.URL.Query().Get("secret")) {
+			return e.NotFoundError("Knock not found", nil)
+		}
+
+		result := map[string]any{
+			"knock_id": knock.Id,
+			"status":   knock.GetString("status"),
+			"room_id":  knock.GetString("room"),
+		}
+
+		// Approved anonymous knock: hand the guest their session
+		if knock.GetString("status") == knockApproved && knock.GetString("user") != "" {
+			guest, err := e.App.FindRecordById("users", knock.GetString("user"))
+			if err == nil && guest.GetBool("guest") {
+				token, err := newGuestSessionToken(guest)
+				if err != nil {
+					return e.InternalServerError("Failed to issue guest session", err)
+				}
+				if token != "" {
+					result["token"] = token
+					result["record"] = guest
+				}
+			}
+		}
+
+		return e.JSON(200, result)
+	})
+
+	// POST /api/hearth/knock/{id}/approve
+	// Lets the guest in: creates a membership (the role the invite granted) vouched for by the approver.
+	se.Router.POST("/api/hearth/knock/{id}/approve", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		knock, room, err := findAnswerableKnock(e, info.Auth)
+		if err != nil {
+			return err
+		}
+
+		err = e.App.RunInTransaction(func(txApp core.App) error {
+			return approveKnock(txApp, knock, room, info.Auth.Id)
+		})
+		if err != nil {
+			return e.BadRequestError("Failed to approve knock", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"knock_id": knock.Id,
+			"status":   knockApproved,
+			"room_id":  room.Id,
+		})
+	}).Bind(apis.RequireAuth())
+
+	// POST /api/hearth/knock/{id}/deny
+	se.Router.POST("/api/hearth/knock/{id}/deny", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+
+		knock, _, err := findAnswerableKnock(e, info.Auth)
+		if err != nil {
+			return err
+		}
+
+		knock.Set("status", knockDenied)
+		knock.Set("answered_by", info.Auth.Id)
+		if err := e.App.Save(knock); err != nil {
+			return e.BadRequestError("Failed to deny knock", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"knock_id": knock.Id,
+			"status":   knockDenied,
+		})
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// findAnswerableKnock loads a pending knock and its room, and verifies that
+// the authenticated user is allowed to answer it.
+func findAnswerableKnock(e *core.RequestEvent, auth *core.Record) (*core.Record, *core.Record, error) {
+	knock, err := e.App.FindRecordById("knocks", e.Request.PathValue("id"))
+	if err != nil {
+		return nil, nil, e.NotFoundError("Knock not found", nil)
+	}
+
+	if knock.GetString("status") != knockPending {
+		return nil, nil, e.BadRequestError("Knock has already been answered", nil)
+	}
+
+	room, err := e.App.FindRecordById("rooms", knock.GetString("room"))
+	if err != nil {
+		return nil, nil, e.NotFoundError("Room not found", nil)
+	}
+
+	if !hasRoomPermission(e.App, room, auth, permManageMembers) {
+		return nil, nil, e.ForbiddenError("You can't answer knocks for this room", nil)
+	}
+
+	return knock, room, nil
+}
+
+// approveKnock lets a knock in: mints a guest account for an anonymous knock, adds the
+// membership the invite granted, and records who answered. Call inside a transaction.
+func approveKnock(txApp core.App, knock, room *core.Record, approverID string) error {
+	userID := knock.GetString("user")
+
+	// Anonymous knock: mint a session-bound guest account for this room
+	if userID == "" {
+		guest, err := createGuestUser(txApp, knock.GetString("display_name"), room.Id)
+		if err != nil {
+			return err
+		}
+		userID = guest.Id
+		knock.Set("user", userID)
+	}
+
+	// Guest accounts only ever hold guest memberships, whatever the invite says
+	role := knock.GetString("grant_role")
+	if role != inviteRoleMember || isGuestUser(txApp, userID) {
+		role = inviteRoleGuest
+	}
+
+	if err := addRoomMember(txApp, room.Id, userID, role, approverID); err != nil {
+		return err
+	}
+
+	knock.Set("status", knockApproved)
+	knock.Set("answered_by", approverID)
+	return txApp.Save(knock)
+}
+
+// isRoomMember reports whether a user currently belongs to a room.
+func isRoomMember(app core.App, roomID, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	_, err := app.FindFirstRecordByFilter(
+		"room_members",
+		"room = {:room} && user = {:user}",
+		dbxParams("room", roomID, "user", userID),
+	)
+	return err == nil
+}
+
+// isGuestUser reports whether a user id belongs to a session-bound guest account.
+func isGuestUser(app core.App, userID string) bool {
+	user, err := app.FindRecordById("users", userID)
+	return err == nil && user.GetBool("guest")
+}
+
+// knockSecretMatches compares a knock secret in constant time.
+func knockSecretMatches(stored, provided string) bool {
+	if stored == "" || provided == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(provided)) == 1
+}
+
+// knockRequiresPoW reports whether knocks must present a PoW token (KNOCK_REQUIRE_POW=true).
+func knockRequiresPoW() bool {
+	return os.Getenv("KNOCK_REQUIRE_POW") == "true"
+}
+
+// addRoomMember creates a room_members row, optionally recording who vouched for the user.
+func addRoomMember(app core.App, roomID, userID, role, vouchedBy string) error {
+	memberCol, err := app.FindCollectionByNameOrId("room_members")
+	if err != nil {
+		return err
+	}
+
+	member := core.NewRecord(memberCol)
+	member.Set("room", roomID)
+	member.Set("user", userID)
+	member.Set("role", role)
+	if vouchedBy != "" {
+		member.Set("vouched_by", vouchedBy)
+	}
+
+	return app.Save(member)
+}
@@ -36,6 +36,15 @@ func RegisterLiveKitToken(app *pocketbase.PocketBase) {
 				return e.ForbiddenError("Not a member of this room", nil)
 			}
 
+			// A blocker can opt out of sharing a call with the user they blocked
+			blocked, err := voiceBlocked(e.App, roomID, info.Auth.Id)
+			if err != nil {
+				return e.InternalServerError("Failed to check voice access", err)
+			}
+			if blocked {
+				return e.ForbiddenError(voiceUnavailableMessage, nil)
+			}
+
 			// Get LiveKit credentials from env
 			apiKey := os.Getenv("LIVEKIT_API_KEY")
 			apiSecret := os.Getenv("LIVEKIT_API_SECRET")
@@ -50,6 +59,7 @@ func RegisterLiveKitToken(app *pocketbase.PocketBase) {
 
 			livekitRoom := room.GetString("livekit_room_name")
 			allowVideo := room.GetBool("allow_video")
+			voiceAdmin := hasRoomPermission(e.App, room, info.Auth, permManageVoice)
 
 			token, err := generateLiveKitToken(
 				apiKey,
@@ -58,17 +68,19 @@ func RegisterLiveKitToken(app *pocketbase.PocketBase) {
 				info.Auth.Id,
 				displayName,
 				allowVideo,
+				voiceAdmin,
 			)
 			if err != nil {
 				e.App.Logger().Error("LiveKit token generation failed", "error", err)
 				return e.InternalServerError("Failed to generate token", nil)
 			}
 
-			return e.JSON(200, map[string]string{
+			return e.JSON(200, map[string]any{
 				"token":       token,
 				"room":        livekitRoom,
 				"identity":    info.Auth.Id,
 				"displayName": displayName,
+				"voiceAdmin":  voiceAdmin,
 			})
 		}).Bind(apis.RequireAuth())
 
@@ -78,11 +90,13 @@ func RegisterLiveKitToken(app *pocketbase.PocketBase) {
 
 // generateLiveKitToken creates a signed JWT for LiveKit room access.
 // Voice-first: only microphone source is allowed unless allowVideo is true.
-func generateLiveKitToken(apiKey, apiSecret, roomName, identity, displayName string, allowVideo bool) (string, error) {
+// voiceAdmin makes the holder a LiveKit room admin (mute and remove participants).
+func generateLiveKitToken(apiKey, apiSecret, roomName, identity, displayName string, allowVideo, voiceAdmin bool) (string, error) {
 	at := auth.NewAccessToken(apiKey, apiSecret)
 
 	grant := &auth.VideoGrant{
 		RoomJoin:     true,
+		RoomAdmin:    voiceAdmin,
 		Room:         roomName,
 		CanPublish:   boolPtr(true),
 		CanSubscribe: boolPtr(true),
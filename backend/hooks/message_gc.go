@@ -5,27 +5,97 @@ import (
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
-// RegisterMessageGC sets up a cron job that sweeps expired messages every minute.
+// RegisterMessageGC sets up a cron job that sweeps expired messages every minute
+// (after burning read burn-after-read messages, see burn.go), then puts out
+// campfires that have gone quiet (see campfires.go).
 // Uses the idx_messages_expires_at index for O(log n) performance.
 func RegisterMessageGC(app *pocketbase.PocketBase) {
 	app.Cron().MustAdd("hearth_message_gc", "* * * * *", func() {
-		now := time.Now().UTC().Format(time.RFC3339)
+		// Burn-after-read messages whose last present reader left since the last pass
+		if burned := sweepReadAcks(app, time.Now()); burned > 0 {
+			app.Logger().Info("burn-after-read sweep", "burned", burned)
+		}
 
-		res, err := app.DB().
-			NewQuery("DELETE FROM messages WHERE expires_at <= {:now}").
-			Bind(dbx.Params{"now": now}).
-			Execute()
+		affected, err := sweepExpiredMessages(app, time.Now())
 		if err != nil {
 			app.Logger().Error("message GC failed", "error", err)
 			return
 		}
 
-		if affected, _ := res.RowsAffected(); affected > 0 {
+		if affected > 0 {
 			app.Logger().Info("message GC sweep", "deleted", affected)
 			// Increment Prometheus counter (tracked in metrics.go)
 			gcDeletedTotal.Add(affected)
 		}
+
+		// A campfire self-destructs once its last messages have faded
+		extinguished, err := sweepIdleCampfires(app, time.Now())
+		if err != nil {
+			app.Logger().Error("campfire GC failed", "error", err)
+			return
+		}
+		if extinguished > 0 {
+			app.Logger().Info("campfire GC sweep", "extinguished", extinguished)
+			campfiresExtinguishedTotal.Add(int64(extinguished))
+		}
+	})
+}
+
+// sweepExpiredMessages deletes messages and DM messages whose expires_at has
+// passed, along with the messages' reactions, revisions and attachments, and
+// unhooks replies to them — a raw DELETE skips PocketBase's cascade, so all of
+// that goes first.
+func sweepExpiredMessages(app core.App, now time.Time) (int64, error) {
+	cutoff, err := types.ParseDateTime(now)
+	if err != nil {
+		return 0, err
+	}
+	params := dbx.Params{"now": cutoff.String()}
+
+	var affected int64
+	err = app.RunInTransaction(func(txApp core.App) error {
+		// Attachments go through the app so their files leave storage too
+		attachments, err := txApp.FindRecordsByFilter("attachments", "message.expires_at <= {:now}", "", 0, 0, params)
+		if err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			if err := txApp.Delete(attachment); err != nil {
+				return err
+			}
+		}
+
+		// Replies outliving their parent keep the quote in their snapshot; a
+		// dangling reply_to would fail every later save of the reply
+		for _, q := range []string{
+			"UPDATE messages SET reply_to = '' WHERE reply_to IN (SELECT id FROM messages WHERE expires_at <= {:now})",
+			"UPDATE dm_messages SET reply_to = '' WHERE reply_to IN (SELECT id FROM dm_messages WHERE expires_at != '' AND expires_at <= {:now})",
+			"DELETE FROM message_reactions WHERE message IN (SELECT id FROM messages WHERE expires_at <= {:now})",
+			"DELETE FROM message_revisions WHERE message IN (SELECT id FROM messages WHERE expires_at <= {:now})",
+		} {
+			if _, err := txApp.DB().NewQuery(q).Bind(params).Execute(); err != nil {
+				return err
+			}
+		}
+
+		// DM messages only come due under a disappearing timer (see dm_timers.go)
+		for _, q := range []string{
+			"DELETE FROM messages WHERE expires_at <= {:now}",
+			"DELETE FROM dm_messages WHERE expires_at != '' AND expires_at <= {:now}",
+		} {
+			res, err := txApp.DB().NewQuery(q).Bind(params).Execute()
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			affected += n
+		}
+		return nil
 	})
+
+	return affected, err
 }
@@ -14,8 +14,11 @@ import (
 // Exported as a Prometheus counter at /metrics.
 var gcDeletedTotal atomic.Int64
 
+// campfiresExtinguishedTotal tracks the cumulative count of idle campfires the GC removed.
+var campfiresExtinguishedTotal atomic.Int64
+
 // RegisterMetrics exposes a Prometheus-compatible /metrics endpoint.
-// Metrics: Go heap, goroutines, room count, online users, messages, GC deletes, WAL pages.
+// Metrics: Go heap, goroutines, room count, online users, messages, GC deletes, extinguished campfires, PoW difficulty, WAL pages.
 func RegisterMetrics(app *pocketbase.PocketBase) {
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 		se.Router.GET("/metrics", func(e *core.RequestEvent) error {
@@ -45,6 +48,11 @@ func RegisterMetrics(app *pocketbase.PocketBase) {
 
 			// GC metrics
 			writeCounter(&b, "hearth_gc_deleted_total", "Total messages deleted by GC", float64(gcDeletedTotal.Load()))
+			writeCounter(&b, "hearth_campfires_extinguished_total", "Total idle campfires deleted by GC", float64(campfiresExtinguishedTotal.Load()))
+
+			// Proof-of-Work metrics
+			writeGauge(&b, "hearth_pow_difficulty_current", "Difficulty (leading zero bits) of the last issued PoW challenge", float64(powDifficultyCurrent.Load()))
+			writeGauge(&b, "hearth_pow_challenges_outstanding", "Unsolved PoW challenges", float64(powChallenges.outstanding()))
 
 			// SQLite WAL metrics
 			walPages, checkpointedPages := getWALStats(e.App)
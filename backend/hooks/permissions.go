@@ -0,0 +1,65 @@
+package hooks
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Per-room grants, stored on room_members.permissions. They let a room owner hand
+// part of the job to a trusted member without handing over the room. Room
+// moderators (see canModerateRoom) implicitly hold every grant; guests hold none.
+const (
+	permModerateMessages = "moderate_messages" // remove other people's messages
+	permManageMembers    = "manage_members"    // answer Knocks, change or remove memberships
+	permManageVoice      = "manage_voice"      // LiveKit room admin: mute and remove participants
+	permInvite           = "invite"            // invites that grant membership or skip the Knock
+	permConfigure        = "configure"         // edit room settings
+)
+
+// roomPermissions lists every grant, in the order the UI shows them.
+var roomPermissions = []string{
+	permModerateMessages,
+	permManageMembers,
+	permManageVoice,
+	permInvite,
+	permConfigure,
+}
+
+// hasRoomPermission reports whether user holds a grant in room, either through
+// their membership or by being able to moderate the room outright.
+func hasRoomPermission(app core.App, room, user *core.Record, permission string) bool {
+	if user == nil {
+		return false
+	}
+	if canModerateRoom(room.GetString("owner"), room.GetStringSlice("keyholders"), user.Id, user.GetString("role")) {
+		return true
+	}
+	if user.GetBool("guest") {
+		return false
+	}
+
+	member, err := app.FindFirstRecordByFilter(
+		"room_members",
+		"room = {:room} && user = {:user}",
+		dbxParams("room", room.Id, "user", user.Id),
+	)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(member.GetStringSlice("permissions"), permission)
+}
+
+// roomGrantRule is the API rule form of the membership half of hasRoomPermission.
+// roomPath is the path from the rule's collection to the room ("" for rooms
+// itself, "room." for collections with a room relation). Both conditions
+// resolve against the same room_members join, so they must hold on one row.
+// The multi-select needs :each — a plain ?= compares the whole JSON array.
+func roomGrantRule(roomPath, permission string) string {
+	via := roomPath + "room_members_via_room"
+	return fmt.Sprintf(
+		`(@request.auth.guest != true && %[1]s.user ?= @request.auth.id && %[1]s.permissions:each ?= %[2]q)`,
+		via, permission,
+	)
+}
@@ -1,9 +1,11 @@
 package hooks
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -17,34 +19,58 @@ import (
 // powChallenge stores an active Proof-of-Work challenge.
 type powChallenge struct {
 	ID         string
+	Algorithm  string
 	Difficulty int
+	Argon2     argon2Params // only for argon2id challenges
 	ExpiresAt  time.Time
 }
 
-// powStore holds active PoW challenges in memory. Short-lived, no DB needed.
+// powTokenTTL is how long a verified PoW token stays spendable.
+const powTokenTTL = 5 * time.Minute
+
+// powStore holds active PoW challenges and unspent PoW tokens in memory.
+// Short-lived, no DB needed.
 type powStore struct {
 	mu         sync.RWMutex
 	challenges map[string]*powChallenge
+	tokens     map[string]time.Time    // token → expiry
+	failures   map[string]*abuseWindow // client IP → failed verifies
 }
 
 var powChallenges = &powStore{
 	challenges: make(map[string]*powChallenge),
+	tokens:     make(map[string]time.Time),
+	failures:   make(map[string]*abuseWindow),
 }
 
 // RegisterPoW sets up the Client Puzzle Protocol endpoints.
-// SHA256 partial collision: client must find nonce where
+// SHA256 partial collision (default): client must find nonce where
 // SHA256(challenge_id + nonce) has N leading zero bits.
+// Argon2id (memory-hard): client must find nonce where
+// Argon2id(nonce, salt=challenge_id) has N leading zero bits.
 func RegisterPoW(app *pocketbase.PocketBase) {
+	// Report the base difficulty until the first challenge is issued
+	powDifficultyCurrent.Store(int64(getPowDifficulty()))
+
 	// Sweep expired challenges every 5 minutes
 	app.Cron().MustAdd("hearth_pow_sweep", "*/5 * * * *", func() {
 		powChallenges.sweep()
 	})
 
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
-		// GET /api/hearth/pow/challenge
-		// Returns a new challenge for the client to solve.
+		// GET /api/hearth/pow/challenge?algorithm=argon2id
+		// Returns a new challenge for the client to solve. The algorithm defaults
+		// to POW_ALGORITHM (sha256 unless configured otherwise).
 		se.Router.GET("/api/hearth/pow/challenge", func(e *core.RequestEvent) error {
-			difficulty := getPowDifficulty()
+			algorithm := e.Request.URL.Query().Get("algorithm")
+			if algorithm == "" {
+				algorithm = getPowAlgorithm()
+			}
+			if algorithm != powAlgorithmSHA256 && algorithm != powAlgorithmArgon2id {
+				return e.BadRequestError("Unsupported algorithm", nil)
+			}
+
+			difficulty := nextPowDifficulty(e.RealIP())
 
 			// Generate random challenge ID
 			challengeID, err := generateRandomHex(16)
@@ -54,19 +80,32 @@ func RegisterPoW(app *pocketbase.PocketBase) {
 
 			expiresAt := time.Now().Add(5 * time.Minute)
 
-			powChallenges.mu.Lock()
-			powChallenges.challenges[challengeID] = &powChallenge{
+			challenge := &powChallenge{
 				ID:         challengeID,
+				Algorithm:  algorithm,
 				Difficulty: difficulty,
 				ExpiresAt:  expiresAt,
 			}
+			if algorithm == powAlgorithmArgon2id {
+				challenge.Difficulty = argon2Difficulty(difficulty)
+				challenge.Argon2 = defaultArgon2Params
+			}
+
+			powChallenges.mu.Lock()
+			powChallenges.challenges[challengeID] = challenge
 			powChallenges.mu.Unlock()
 
-			return e.JSON(200, map[string]any{
+			resp := map[string]any{
 				"challenge_id": challengeID,
-				"difficulty":   difficulty,
+				"algorithm":    algorithm,
+				"difficulty":   challenge.Difficulty,
 				"expires":      expiresAt.Unix(),
-			})
+			}
+			if algorithm == powAlgorithmArgon2id {
+				resp["params"] = challenge.Argon2
+			}
+
+			return e.JSON(200, resp)
 		})
 
 		// POST /api/hearth/pow/verify
@@ -98,7 +137,16 @@ func RegisterPoW(app *pocketbase.PocketBase) {
 			}
 
 			// Verify the solution
-			if !verifyPoW(challenge.ID, data.Nonce, challenge.Difficulty) {
+			valid, err := verifyChallenge(e.Request.Context(), challenge, data.Nonce)
+			if errors.Is(err, errArgon2Busy) {
+				// Not the client's fault — hand the challenge back so they can retry
+				powChallenges.mu.Lock()
+				powChallenges.challenges[challenge.ID] = challenge
+				powChallenges.mu.Unlock()
+				return e.TooManyRequestsError(err.Error(), nil)
+			}
+			if !valid {
+				powChallenges.recordFailure(e.RealIP())
 				return e.BadRequestError("Invalid solution", nil)
 			}
 
@@ -108,9 +156,13 @@ func RegisterPoW(app *pocketbase.PocketBase) {
 				return e.InternalServerError("Failed to generate token", err)
 			}
 
+			expiresAt := time.Now().Add(powTokenTTL)
+			powChallenges.issueToken(token, expiresAt)
+
 			return e.JSON(200, map[string]any{
-				"valid": true,
-				"token": token,
+				"valid":   true,
+				"token":   token,
+				"expires": expiresAt.Unix(),
 			})
 		})
 
@@ -118,10 +170,36 @@ func RegisterPoW(app *pocketbase.PocketBase) {
 	})
 }
 
+// verifyChallenge dispatches a solution to the verifier matching the challenge's algorithm.
+// Argon2id verifications wait for a slot; errArgon2Busy means none freed up in time.
+func verifyChallenge(ctx context.Context, c *powChallenge, nonce string) (bool, error) {
+	switch c.Algorithm {
+	case powAlgorithmArgon2id:
+		release, err := acquireArgon2Slot(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+		return verifyArgon2PoW(c.ID, nonce, c.Difficulty, c.Argon2), nil
+	case powAlgorithmSHA256, "":
+		return verifyPoW(c.ID, nonce, c.Difficulty), nil
+	default:
+		return false, nil
+	}
+}
+
 // verifyPoW checks if SHA256(challengeID + nonce) has `difficulty` leading zero bits.
 func verifyPoW(challengeID, nonce string, difficulty int) bool {
 	input := challengeID + nonce
 	hash := sha256.Sum256([]byte(input))
+	return hasLeadingZeroBits(hash[:], difficulty)
+}
+
+// hasLeadingZeroBits reports whether hash starts with at least `difficulty` zero bits.
+func hasLeadingZeroBits(hash []byte, difficulty int) bool {
+	if difficulty > len(hash)*8 {
+		return false
+	}
 
 	// Check leading zero bits
 	bitsChecked := 0
@@ -155,7 +233,8 @@ func generateRandomHex(numBytes int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// getPowDifficulty reads difficulty from env or returns default (20 bits).
+// getPowDifficulty reads the base difficulty from env or returns default (20 bits).
+// The adaptive policy builds on top of this (see pow_difficulty.go).
 func getPowDifficulty() int {
 	s := os.Getenv("POW_DIFFICULTY")
 	if s == "" {
@@ -168,7 +247,75 @@ func getPowDifficulty() int {
 	return d
 }
 
-// sweep removes expired challenges from the in-memory store.
+// outstanding returns the number of unsolved challenges.
+func (ps *powStore) outstanding() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.challenges)
+}
+
+// recordFailure notes a failed verify attempt from ip.
+func (ps *powStore) recordFailure(ip string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	w, exists := ps.failures[ip]
+	if !exists {
+		w = &abuseWindow{since: time.Now()}
+		ps.failures[ip] = w
+	}
+	w.add(time.Now())
+}
+
+// recentFailures returns failed verify attempts from ip in the current window.
+func (ps *powStore) recentFailures(ip string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.failures[ip].recent(time.Now())
+}
+
+// issueToken records a verified PoW token as spendable until expiresAt.
+func (ps *powStore) issueToken(token string, expiresAt time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.tokens[token] = expiresAt
+}
+
+// consumeToken spends a PoW token. Returns false if the token is unknown,
+// already spent, or expired. Tokens are single-use either way.
+func (ps *powStore) consumeToken(token string) bool {
+	_, ok := ps.spendToken(token)
+	return ok
+}
+
+// spendToken is consumeToken that also returns the token's expiry, so a caller
+// whose work then fails can hand it back with issueToken.
+func (ps *powStore) spendToken(token string) (time.Time, bool) {
+	if token == "" {
+		return time.Time{}, false
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	expiresAt, exists := ps.tokens[token]
+	if !exists {
+		return time.Time{}, false
+	}
+	delete(ps.tokens, token)
+
+	return expiresAt, time.Now().Before(expiresAt)
+}
+
+// getPowAlgorithm reads the default challenge algorithm from env (sha256 or argon2id).
+func getPowAlgorithm() string {
+	if os.Getenv("POW_ALGORITHM") == powAlgorithmArgon2id {
+		return powAlgorithmArgon2id
+	}
+	return powAlgorithmSHA256
+}
+
+// sweep removes expired challenges and tokens from the in-memory store.
 func (ps *powStore) sweep() {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
@@ -179,6 +326,16 @@ func (ps *powStore) sweep() {
 			delete(ps.challenges, k)
 		}
 	}
+	for k, v := range ps.tokens {
+		if now.After(v) {
+			delete(ps.tokens, k)
+		}
+	}
+	for k, v := range ps.failures {
+		if v.recent(now) == 0 {
+			delete(ps.failures, k)
+		}
+	}
 }
 
 
@@ -0,0 +1,80 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PoW challenge algorithms.
+const (
+	powAlgorithmSHA256   = "sha256"
+	powAlgorithmArgon2id = "argon2id"
+)
+
+// argon2Params are the server-chosen Argon2id cost parameters sent with a challenge.
+// Memory-hardness is what levels the field: a GPU has thousands of cores but not
+// thousands × 16 MiB of fast memory, so an attacker's per-guess cost approaches a phone's.
+type argon2Params struct {
+	Memory  uint32 `json:"memory"` // KiB
+	Time    uint32 `json:"time"`   // passes
+	Threads uint8  `json:"threads"`
+	KeyLen  uint32 `json:"key_len"`
+}
+
+// defaultArgon2Params keep a single hash around 30–80ms on a phone while fitting
+// the server's memory budget (verification is capped by argon2VerifySlots).
+var defaultArgon2Params = argon2Params{
+	Memory:  16 * 1024,
+	Time:    2,
+	Threads: 1,
+	KeyLen:  32,
+}
+
+// argon2DifficultyOffset converts SHA256 difficulty into Argon2id difficulty.
+// Each Argon2id guess costs ~2^16 SHA256 guesses, so base 20 becomes 4 bits (~16 hashes).
+const argon2DifficultyOffset = 16
+
+// argon2VerifySlots bounds concurrent Argon2id verifications — each one allocates
+// params.Memory KiB, and a verify flood must not blow the 250 MiB PocketBase budget.
+var argon2VerifySlots = make(chan struct{}, 2)
+
+// argon2SlotWait is how long a verification waits for a free slot before the
+// request is turned away, so a flood can't pile up open requests.
+const argon2SlotWait = 2 * time.Second
+
+var errArgon2Busy = errors.New("Too many verifications in progress, try again shortly")
+
+// acquireArgon2Slot waits for a verification slot until argon2SlotWait passes or
+// ctx is done. The caller must call release once the hash is computed.
+func acquireArgon2Slot(ctx context.Context) (release func(), err error) {
+	timer := time.NewTimer(argon2SlotWait)
+	defer timer.Stop()
+
+	select {
+	case argon2VerifySlots <- struct{}{}:
+		return func() { <-argon2VerifySlots }, nil
+	case <-ctx.Done():
+		return nil, errArgon2Busy
+	case <-timer.C:
+		return nil, errArgon2Busy
+	}
+}
+
+// argon2Difficulty maps a SHA256 leading-zero-bit difficulty onto the Argon2id scale.
+func argon2Difficulty(shaDifficulty int) int {
+	d := shaDifficulty - argon2DifficultyOffset
+	if d < 1 {
+		d = 1
+	}
+	return d
+}
+
+// verifyArgon2PoW checks if Argon2id(nonce, salt=challengeID) has `difficulty` leading zero bits.
+// Callers serving requests hold a slot from acquireArgon2Slot (see verifyChallenge).
+func verifyArgon2PoW(challengeID, nonce string, difficulty int, params argon2Params) bool {
+	hash := argon2.IDKey([]byte(nonce), []byte(challengeID), params.Time, params.Memory, params.Threads, params.KeyLen)
+	return hasLeadingZeroBits(hash, difficulty)
+}
@@ -0,0 +1,121 @@
+package hooks
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// PowSignals are the live abuse signals a difficulty policy sees for one challenge.
+type PowSignals struct {
+	Outstanding         int // unsolved challenges across the House
+	RateLimitRejections int // recent rate-limit rejections for the client IP
+	FailedVerifies      int // recent failed /pow/verify attempts for the client IP
+}
+
+// PowDifficultyPolicy decides how many leading zero bits a new challenge requires.
+// Swap the active policy with SetPowDifficultyPolicy.
+type PowDifficultyPolicy interface {
+	Difficulty(signals PowSignals) int
+}
+
+// FixedPowPolicy always issues the same difficulty, ignoring signals.
+type FixedPowPolicy int
+
+// Difficulty implements PowDifficultyPolicy.
+func (p FixedPowPolicy) Difficulty(PowSignals) int {
+	return int(p)
+}
+
+// AdaptivePowPolicy starts at the base difficulty (honest users: ~1s puzzles) and
+// adds bits as abuse signals grow, so floods climb to 24+ bits automatically.
+// Each signal contributes log2-scaled bits once it crosses its threshold.
+type AdaptivePowPolicy struct {
+	Base int // 0 = read POW_DIFFICULTY via getPowDifficulty()
+	Max  int // hard ceiling, keeps puzzles solvable on a phone eventually
+
+	OutstandingThreshold int // House-wide unsolved challenges before adding bits
+	RejectionThreshold   int // per-IP rate-limit rejections before adding bits
+	FailureThreshold     int // per-IP failed verifies before adding bits
+}
+
+// defaultAdaptivePowPolicy returns the policy Hearth ships with.
+func defaultAdaptivePowPolicy() *AdaptivePowPolicy {
+	return &AdaptivePowPolicy{
+		Max:                  28,
+		OutstandingThreshold: 64,
+		RejectionThreshold:   1,
+		FailureThreshold:     3,
+	}
+}
+
+// Difficulty implements PowDifficultyPolicy.
+func (p *AdaptivePowPolicy) Difficulty(s PowSignals) int {
+	base := p.Base
+	if base <= 0 {
+		base = getPowDifficulty()
+	}
+
+	d := base
+	d += scaledBits(s.Outstanding, p.OutstandingThreshold)
+	// Rate-limit rejections and failed verifies are per-IP evidence — weigh them double
+	d += 2 * scaledBits(s.RateLimitRejections, p.RejectionThreshold)
+	d += 2 * scaledBits(s.FailedVerifies, p.FailureThreshold)
+
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	if d < base {
+		d = base
+	}
+	return d
+}
+
+// scaledBits returns 0 below threshold, then 1 + log2(n/threshold):
+// threshold → 1, 2×threshold → 2, 4×threshold → 3, ...
+func scaledBits(n, threshold int) int {
+	if threshold <= 0 || n < threshold {
+		return 0
+	}
+	return bits.Len(uint(n / threshold))
+}
+
+var (
+	powPolicyMu sync.RWMutex
+	powPolicy   PowDifficultyPolicy = defaultAdaptivePowPolicy()
+)
+
+// powDifficultyCurrent is the difficulty of the most recently issued challenge.
+// Exported as a Prometheus gauge at /metrics.
+var powDifficultyCurrent atomic.Int64
+
+// SetPowDifficultyPolicy replaces the active difficulty policy.
+func SetPowDifficultyPolicy(p PowDifficultyPolicy) {
+	powPolicyMu.Lock()
+	defer powPolicyMu.Unlock()
+	powPolicy = p
+}
+
+// nextPowDifficulty computes the difficulty for a new challenge from the client IP's signals.
+func nextPowDifficulty(ip string) int {
+	signals := PowSignals{
+		Outstanding:         powChallenges.outstanding(),
+		RateLimitRejections: limiter.RecentRejections(ip),
+		FailedVerifies:      powChallenges.recentFailures(ip),
+	}
+
+	powPolicyMu.RLock()
+	d := powPolicy.Difficulty(signals)
+	powPolicyMu.RUnlock()
+
+	// verifyPoW works on a 256-bit hash, but anything past 32 is unsolvable in practice
+	if d < 1 {
+		d = 1
+	}
+	if d > 32 {
+		d = 32
+	}
+
+	powDifficultyCurrent.Store(int64(d))
+	return d
+}
@@ -44,12 +44,18 @@ func (pm *PresenceMap) Heartbeat(userID, roomID, displayName string) {
 
 // GetRoomPresence returns all online users in a specific room.
 func (pm *PresenceMap) GetRoomPresence(roomID string) []*PresenceEntry {
+	return pm.GetRoomPresenceExcept(roomID, nil)
+}
+
+// GetRoomPresenceExcept returns the online users in a room, leaving out the
+// users in hidden (e.g. those who have blocked the viewer).
+func (pm *PresenceMap) GetRoomPresenceExcept(roomID string, hidden map[string]bool) []*PresenceEntry {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
 	var result []*PresenceEntry
 	for _, entry := range pm.entries {
-		if entry.RoomID == roomID {
+		if entry.RoomID == roomID && !hidden[entry.UserID] {
 			result = append(result, entry)
 		}
 	}
@@ -148,7 +154,13 @@ func RegisterPresence(app *pocketbase.PocketBase) {
 				return e.ForbiddenError("Not a member of this room", nil)
 			}
 
-			entries := presence.GetRoomPresence(roomID)
+			// Whoever has blocked the viewer isn't shown to them
+			blockers, err := blockersOf(e.App, info.Auth.Id)
+			if err != nil {
+				return e.InternalServerError("Failed to load presence", err)
+			}
+
+			entries := presence.GetRoomPresenceExcept(roomID, blockers)
 			return e.JSON(200, map[string]any{
 				"online": entries,
 				"count":  len(entries),
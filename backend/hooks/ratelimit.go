@@ -12,8 +12,35 @@ import (
 // RateLimiter implements a sliding-window token bucket rate limiter.
 // In-memory only — no Redis needed. A sync.Mutex map with periodic sweep.
 type RateLimiter struct {
-	mu      sync.Mutex
-	buckets map[string]*rateBucket
+	mu         sync.Mutex
+	buckets    map[string]*rateBucket
+	rejections map[string]*abuseWindow // key: client IP
+}
+
+// abuseSignalWindow is how long abuse signals (rejections, failed verifies) are remembered.
+const abuseSignalWindow = 5 * time.Minute
+
+// abuseWindow counts events in a fixed window that resets after abuseSignalWindow.
+type abuseWindow struct {
+	count int
+	since time.Time
+}
+
+// add records one event, starting a fresh window if the current one has lapsed.
+func (w *abuseWindow) add(now time.Time) {
+	if now.Sub(w.since) > abuseSignalWindow {
+		w.count = 0
+		w.since = now
+	}
+	w.count++
+}
+
+// recent returns the event count if the window is still live, else 0.
+func (w *abuseWindow) recent(now time.Time) int {
+	if w == nil || now.Sub(w.since) > abuseSignalWindow {
+		return 0
+	}
+	return w.count
 }
 
 type rateBucket struct {
@@ -35,8 +62,10 @@ var (
 	rateLimitAuth = RateLimitConfig{MaxTokens: 5, RefillRate: 5.0 / 900.0}
 	// Auth refresh (token keepalive): 10 requests per minute — generous, it's not a login attempt
 	rateLimitAuthRefresh = RateLimitConfig{MaxTokens: 10, RefillRate: 10.0 / 60.0}
-	// Invite validation: 10 requests per minute
+	// Invite validation and short-code redemption: 10 requests per minute
 	rateLimitInvite = RateLimitConfig{MaxTokens: 10, RefillRate: 10.0 / 60.0}
+	// Knock creation: 3 knocks per 10 minutes — nobody needs to hammer the door
+	rateLimitKnock = RateLimitConfig{MaxTokens: 3, RefillRate: 3.0 / 600.0}
 	// General API: 120 requests per minute (covers room navigation bursts)
 	rateLimitGeneral = RateLimitConfig{MaxTokens: 120, RefillRate: 2.0}
 	// Message creation: 30 messages per minute (per user)
@@ -48,7 +77,8 @@ var (
 // NewRateLimiter creates a new in-memory rate limiter.
 func NewRateLimiter() *RateLimiter {
 	return &RateLimiter{
-		buckets: make(map[string]*rateBucket),
+		buckets:    make(map[string]*rateBucket),
+		rejections: make(map[string]*abuseWindow),
 	}
 }
 
@@ -99,9 +129,35 @@ func (rl *RateLimiter) SweepStale(maxAge time.Duration) int {
 			removed++
 		}
 	}
+	for ip, w := range rl.rejections {
+		if w.recent(time.Now()) == 0 {
+			delete(rl.rejections, ip)
+		}
+	}
 	return removed
 }
 
+// RecordRejection notes that a request from ip was rate limited.
+// Feeds the adaptive PoW difficulty policy.
+func (rl *RateLimiter) RecordRejection(ip string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	w, exists := rl.rejections[ip]
+	if !exists {
+		w = &abuseWindow{since: time.Now()}
+		rl.rejections[ip] = w
+	}
+	w.add(time.Now())
+}
+
+// RecentRejections returns how many requests from ip were rate limited in the current window.
+func (rl *RateLimiter) RecentRejections(ip string) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rejections[ip].recent(time.Now())
+}
+
 // BucketCount returns the number of active rate limit buckets (for metrics/testing).
 func (rl *RateLimiter) BucketCount() int {
 	rl.mu.Lock()
@@ -142,6 +198,9 @@ func RegisterRateLimit(app *pocketbase.PocketBase) {
 			case isInvitePath(path):
 				config = rateLimitInvite
 				key = "invite:" + ip
+			case isKnockCreatePath(path, e.Request.Method):
+				config = rateLimitKnock
+				key = "knock:" + ip
 			case isMessageCreatePath(path, e.Request.Method):
 				// Per-user rate limit for message creation
 				config = rateLimitMessage
@@ -165,6 +224,7 @@ func RegisterRateLimit(app *pocketbase.PocketBase) {
 			}
 
 			if !limiter.Allow(key, config) {
+				limiter.RecordRejection(ip)
 				app.Logger().Warn("rate limit exceeded",
 					"key", key,
 					"ip", ip,
@@ -195,7 +255,12 @@ func isAuthRefreshPath(path string) bool {
 }
 
 func isInvitePath(path string) bool {
-	return matchPrefix(path, "/api/hearth/invite/validate")
+	return matchPrefix(path, "/api/hearth/invite/validate") ||
+		matchPrefix(path, "/api/hearth/invite/redeem/")
+}
+
+func isKnockCreatePath(path string, method string) bool {
+	return method == "POST" && path == "/api/hearth/knock"
 }
 
 func isMessageCreatePath(path string, method string) bool {
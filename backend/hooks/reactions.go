@@ -0,0 +1,70 @@
+package hooks
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterReactions keeps messages.reaction_counts in step with message_reactions,
+// so clients get per-emoji totals with the message (and its realtime updates)
+// instead of fetching every reaction row. A client finds its own reactions with
+// a filter on message_reactions.user.
+func RegisterReactions(app *pocketbase.PocketBase) {
+	bindReactionHooks(app)
+}
+
+// bindReactionHooks binds the count hooks.
+func bindReactionHooks(app core.App) {
+	// A new message starts with no reactions, whatever the client sends
+	app.OnRecordCreate("messages").BindFunc(func(e *core.RecordEvent) error {
+		e.Record.Set("reaction_counts", map[string]int{})
+		return e.Next()
+	})
+
+	app.OnRecordAfterCreateSuccess("message_reactions").BindFunc(func(e *core.RecordEvent) error {
+		if err := refreshReactionCounts(e.App, e.Record.GetString("message")); err != nil {
+			e.App.Logger().Error("failed to refresh reaction counts", "error", err, "message", e.Record.GetString("message"))
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterDeleteSuccess("message_reactions").BindFunc(func(e *core.RecordEvent) error {
+		if err := refreshReactionCounts(e.App, e.Record.GetString("message")); err != nil {
+			e.App.Logger().Error("failed to refresh reaction counts", "error", err, "message", e.Record.GetString("message"))
+		}
+		return e.Next()
+	})
+}
+
+// refreshReactionCounts recounts a message's reactions into messages.reaction_counts
+// ({"🔥": 3, "❤️": 1}). Recounting rather than incrementing keeps the field right
+// even when two reactions land at once. A message that's already gone is a no-op.
+func refreshReactionCounts(app core.App, messageID string) error {
+	message, err := app.FindRecordById("messages", messageID)
+	if err != nil {
+		return nil
+	}
+
+	rows := []struct {
+		Emoji string `db:"emoji"`
+		Total int    `db:"total"`
+	}{}
+	err = app.DB().
+		Select("emoji", "COUNT(*) AS total").
+		From("message_reactions").
+		Where(dbx.HashExp{"message": messageID}).
+		GroupBy("emoji").
+		All(&rows)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Emoji] = row.Total
+	}
+
+	message.Set("reaction_counts", counts)
+	return app.Save(message)
+}
@@ -0,0 +1,160 @@
+package hooks
+
+import (
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// replyExcerptLength is how much of the parent a reply quotes (runes).
+const replyExcerptLength = 140
+
+// maxThreadReplies caps the thread endpoint — a campfire branch, not an archive.
+const maxThreadReplies = 500
+
+// RegisterReplies sets up replies and lightweight threads. A message (or DM) may
+// set reply_to to another message in the same room (or DM); the server snapshots
+// the parent's author and an excerpt onto the reply so the quote still renders
+// once the parent has faded.
+func RegisterReplies(app *pocketbase.PocketBase) {
+	bindReplyHooks(app)
+	app.OnServe().BindFunc(replyRoutes)
+}
+
+// bindReplyHooks binds the reply snapshot hooks.
+func bindReplyHooks(app core.App) {
+	app.OnRecordCreate("messages").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("reply_to") == "" {
+			// The quote is the server's to write — never take one from the client
+			clearReplySnapshot(e.Record)
+			return e.Next()
+		}
+
+		parent, err := e.App.FindRecordById("messages", e.Record.GetString("reply_to"))
+		if err != nil || parent.GetString("room") != e.Record.GetString("room") {
+			return replyToError("validation_reply_other_room", "You can only reply to a message in the same room")
+		}
+
+		room, err := e.App.FindRecordById("rooms", e.Record.GetString("room"))
+		if err != nil {
+			return err
+		}
+
+		snapshotReplyParent(e.Record, parent)
+
+		// A reply lives as long as any message in its room (see auth.go) — it
+		// never inherits a longer life from its parent or the client
+		e.Record.Set("expires_at", messageExpiresAt(room, e.Record.GetInt("ttl"), time.Now()))
+
+		return e.Next()
+	})
+
+	app.OnRecordCreate("dm_messages").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("reply_to") == "" {
+			// The quote is the server's to write — never take one from the client
+			clearReplySnapshot(e.Record)
+			return e.Next()
+		}
+
+		parent, err := e.App.FindRecordById("dm_messages", e.Record.GetString("reply_to"))
+		if err != nil || parent.GetString("dm") != e.Record.GetString("dm") {
+			return replyToError("validation_reply_other_dm", "You can only reply to a message in the same conversation")
+		}
+
+		snapshotReplyParent(e.Record, parent)
+
+		return e.Next()
+	})
+}
+
+// replyRoutes registers the thread endpoint.
+func replyRoutes(se *core.ServeEvent) error {
+	// GET /api/hearth/messages/{id}/thread
+	// Returns: { "message": {...}, "replies": [...] } — every live descendant, oldest first.
+	// Visible to whoever can view the message itself (room members).
+	se.Router.GET("/api/hearth/messages/{id}/thread", func(e *core.RequestEvent) error {
+		info, err := e.RequestInfo()
+		if err != nil {
+			return e.BadRequestError("Invalid request", err)
+		}
+
+		message, err := e.App.FindRecordById("messages", e.Request.PathValue("id"))
+		if err != nil {
+			return e.NotFoundError("Message not found", nil)
+		}
+
+		canView, err := e.App.CanAccessRecord(message, info, message.Collection().ViewRule)
+		if !canView {
+			return e.NotFoundError("Message not found", err)
+		}
+
+		replies, err := findThreadReplies(e.App, message.Id)
+		if err != nil {
+			return e.InternalServerError("Failed to load thread", err)
+		}
+
+		return e.JSON(200, map[string]any{
+			"message": message,
+			"replies": replies,
+		})
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// findThreadReplies returns the unexpired descendants of a message, oldest first.
+// A branch ends where the GC has already swept a reply; its own replies keep
+// their quote snapshot but no longer show up here.
+func findThreadReplies(app core.App, messageID string) ([]*core.Record, error) {
+	replies := []*core.Record{}
+	err := app.RecordQuery("messages").
+		AndWhere(dbx.NewExp(
+			`[[messages.id]] IN (
+				WITH RECURSIVE thread(id) AS (
+					SELECT id FROM messages WHERE reply_to = {:root}
+					UNION
+					SELECT m.id FROM messages m JOIN thread t ON m.reply_to = t.id
+				)
+				SELECT id FROM thread
+			)`,
+			dbx.Params{"root": messageID},
+		)).
+		AndWhere(dbx.NewExp("[[messages.expires_at]] > {:now}", dbx.Params{"now": types.NowDateTime().String()})).
+		OrderBy("created ASC").
+		Limit(maxThreadReplies).
+		All(&replies)
+	return replies, err
+}
+
+// snapshotReplyParent copies the parent's author and a short excerpt onto a reply.
+func snapshotReplyParent(reply, parent *core.Record) {
+	reply.Set("reply_author_name", parent.GetString("author_name"))
+	reply.Set("reply_excerpt", replyExcerpt(parent.GetString("body")))
+}
+
+// clearReplySnapshot empties the quote fields of a message that isn't a reply.
+func clearReplySnapshot(msg *core.Record) {
+	msg.Set("reply_author_name", "")
+	msg.Set("reply_excerpt", "")
+}
+
+// replyExcerpt shortens a quoted body to replyExcerptLength runes on one line.
+func replyExcerpt(body string) string {
+	excerpt := strings.Join(strings.Fields(body), " ")
+	runes := []rune(excerpt)
+	if len(runes) <= replyExcerptLength {
+		return excerpt
+	}
+	return strings.TrimSpace(string(runes[:replyExcerptLength])) + "…"
+}
+
+// replyToError is a field error on reply_to, surfaced as a 400 by the records API.
+func replyToError(code, message string) error {
+	return validation.Errors{"reply_to": validation.NewError(code, message)}
+}
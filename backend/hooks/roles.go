@@ -0,0 +1,77 @@
+package hooks
+
+import (
+	"slices"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterRoles enforces the House roles (ADR-007) outside the API rules:
+// nobody can pick their own role at sign-up or promote themselves later.
+//
+//   - Homeowner: can do anything.
+//   - Keyholder: creates and configures dens; moderates rooms delegated to them
+//     (listed in rooms.keyholders).
+//   - Member: creates campfires if the House allows it (house_settings).
+//
+// Who may create, configure and moderate rooms is expressed in applyAPIRules.
+func RegisterRoles(app *pocketbase.PocketBase) {
+	bindRoleHooks(app)
+}
+
+// bindRoleHooks binds the role guards. Like every bindXHooks and xRoutes helper
+// in this package, it's split from its RegisterX (which takes the full
+// *pocketbase.PocketBase) so integration tests can bind hooks on, or serve
+// routes from, a plain test app.
+func bindRoleHooks(app core.App) {
+	// Sign-up always starts as a member (the first user is crowned after create)
+	app.OnRecordCreateRequest("users").BindFunc(func(e *core.RecordRequestEvent) error {
+		if !e.HasSuperuserAuth() {
+			e.Record.Set("role", "member")
+		}
+		return e.Next()
+	})
+
+	// Roles can't be changed through the records API (see admin.go). Superusers
+	// still can, from the dashboard — and that goes in the audit log too.
+	app.OnRecordUpdateRequest("users").BindFunc(func(e *core.RecordRequestEvent) error {
+		previous := e.Record.Original().GetString("role")
+		current := e.Record.GetString("role")
+		if previous == current {
+			return e.Next()
+		}
+
+		if !e.HasSuperuserAuth() {
+			return e.ForbiddenError("You can't change your own role", nil)
+		}
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if err := writeAudit(e.App, auditRoleChange, "", e.Record, map[string]any{
+			"from": previous,
+			"to":   current,
+			"via":  "superuser",
+		}); err != nil {
+			e.App.Logger().Error("failed to audit role change", "error", err, "user", e.Record.Id)
+		}
+		return nil
+	})
+}
+
+// canModerateRoom reports whether a user may moderate a room (answer knocks,
+// remove members and messages, change settings): the room owner, the Homeowner,
+// or a Keyholder the room has been delegated to.
+func canModerateRoom(roomOwnerID string, keyholderIDs []string, userID, userRole string) bool {
+	if userID == "" {
+		return false
+	}
+	switch {
+	case userID == roomOwnerID, userRole == "homeowner":
+		return true
+	case userRole == "keyholder":
+		return slices.Contains(keyholderIDs, userID)
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package hooks
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Search result limits for /api/hearth/search.
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 50
+)
+
+// Snippet highlight markers. Clients split on them rather than rendering HTML.
+const (
+	searchMarkOpen  = "<mark>"
+	searchMarkClose = "</mark>"
+)
+
+// searchIndexSQL keeps full-text indexes of den messages and DMs. Each index is a
+// plain table with a stable INTEGER PRIMARY KEY (the collections' rowids move on
+// VACUUM) backing an external-content FTS5 table. Triggers on the collections keep
+// them in sync — including the raw DELETEs of the message GC and the guest sweep,
+// which skip PocketBase's record hooks. Campfire messages are never indexed; a room
+// that stops being a den is dropped from the index, and one that becomes a den is
+// added. Every statement is idempotent: the triggers are recreated on each start.
+var searchIndexSQL = []string{
+	// Den messages
+	`CREATE TABLE IF NOT EXISTS messages_search (
+		id         INTEGER PRIMARY KEY,
+		message_id TEXT NOT NULL UNIQUE,
+		room       TEXT NOT NULL,
+		author     TEXT NOT NULL,
+		created    TEXT NOT NULL,
+		body       TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_search_room ON messages_search (room, created)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		body, content='messages_search', content_rowid='id', tokenize='unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS messages_search_ai AFTER INSERT ON messages_search BEGIN
+		INSERT INTO messages_fts (rowid, body) VALUES (NEW.id, NEW.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_search_ad AFTER DELETE ON messages_search BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, body) VALUES ('delete', OLD.id, OLD.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_search_au AFTER UPDATE OF body ON messages_search BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, body) VALUES ('delete', OLD.id, OLD.body);
+		INSERT INTO messages_fts (rowid, body) VALUES (NEW.id, NEW.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_index_ai AFTER INSERT ON messages
+	WHEN (SELECT type FROM rooms WHERE id = NEW.room) = 'den' BEGIN
+		INSERT OR IGNORE INTO messages_search (message_id, room, author, created, body)
+		VALUES (NEW.id, NEW.room, NEW.author, NEW.created, NEW.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_index_au AFTER UPDATE OF body ON messages BEGIN
+		UPDATE messages_search SET body = NEW.body WHERE message_id = NEW.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_index_ad AFTER DELETE ON messages BEGIN
+		DELETE FROM messages_search WHERE message_id = OLD.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS rooms_index_type AFTER UPDATE OF type ON rooms
+	WHEN OLD.type IS NOT NEW.type BEGIN
+		DELETE FROM messages_search WHERE room = NEW.id AND NEW.type != 'den';
+		INSERT OR IGNORE INTO messages_search (message_id, room, author, created, body)
+		SELECT id, room, author, created, body FROM messages WHERE room = NEW.id AND NEW.type = 'den';
+	END`,
+
+	// DMs
+	`CREATE TABLE IF NOT EXISTS dm_messages_search (
+		id         INTEGER PRIMARY KEY,
+		message_id TEXT NOT NULL UNIQUE,
+		dm         TEXT NOT NULL,
+		author     TEXT NOT NULL,
+		created    TEXT NOT NULL,
+		body       TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_dm_messages_search_dm ON dm_messages_search (dm, created)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS dm_messages_fts USING fts5(
+		body, content='dm_messages_search', content_rowid='id', tokenize='unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS dm_messages_search_ai AFTER INSERT ON dm_messages_search BEGIN
+		INSERT INTO dm_messages_fts (rowid, body) VALUES (NEW.id, NEW.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS dm_messages_search_ad AFTER DELETE ON dm_messages_search BEGIN
+		INSERT INTO dm_messages_fts (dm_messages_fts, rowid, body) VALUES ('delete', OLD.id, OLD.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS dm_messages_search_au AFTER UPDATE OF body ON dm_messages_search BEGIN
+		INSERT INTO dm_messages_fts (dm_messages_fts, rowid, body) VALUES ('delete', OLD.id, OLD.body);
+		INSERT INTO dm_messages_fts (rowid, body) VALUES (NEW.id, NEW.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS dm_messages_index_ai AFTER INSERT ON dm_messages BEGIN
+		INSERT OR IGNORE INTO dm_messages_search (message_id, dm, author, created, body)
+		VALUES (NEW.id, NEW.dm, NEW.author, NEW.created, NEW.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS dm_messages_index_au AFTER UPDATE OF body ON dm_messages BEGIN
+		UPDATE dm_messages_search SET body = NEW.body WHERE message_id = NEW.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS dm_messages_index_ad AFTER DELETE ON dm_messages BEGIN
+		DELETE FROM dm_messages_search WHERE message_id = OLD.id;
+	END`,
+
+	// Backfill history that predates the index (a no-op once indexed)
+	`INSERT OR IGNORE INTO messages_search (message_id, room, author, created, body)
+	SELECT m.id, m.room, m.author, m.created, m.body FROM messages m JOIN rooms r ON r.id = m.room WHERE r.type = 'den'`,
+	`INSERT OR IGNORE INTO dm_messages_search (message_id, dm, author, created, body)
+	SELECT id, dm, author, created, body FROM dm_messages`,
+}
+
+// ensureSearchIndex creates the FTS5 search tables and their sync triggers.
+// Runs after the collections exist (the triggers reference them).
+func ensureSearchIndex(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		for _, stmt := range searchIndexSQL {
+			if _, err := txApp.DB().NewQuery(stmt).Execute(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// searchHit is one ranked search result. Room is set for den messages, DM for DMs.
+type searchHit struct {
+	ID         string  `db:"id" json:"id"`
+	Room       string  `db:"room" json:"room,omitempty"`
+	DM         string  `db:"dm" json:"dm,omitempty"`
+	Author     string  `db:"author" json:"author"`
+	AuthorName string  `db:"author_name" json:"author_name"`
+	Created    string  `db:"created" json:"created"`
+	Snippet    string  `db:"snippet" json:"snippet"`
+	Rank       float64 `db:"rank" json:"rank"`
+}
+
+// searchFilter narrows a search. Empty fields don't filter.
+type searchFilter struct {
+	Room   string
+	DM     string
+	Author string
+	From   string // inclusive, DB datetime format
+	To     string // exclusive, DB datetime format
+	Limit  int
+}
+
+// RegisterSearch sets up full-text search over den history and DMs.
+// The indexes themselves are created with the collections (see collections.go).
+func RegisterSearch(app *pocketbase.PocketBase) {
+	app.OnServe().BindFunc(searchRoutes)
+}
+
+// searchRoutes registers the search endpoint.
+func searchRoutes(se *core.ServeEvent) error {
+	// GET /api/hearth/search?q=marshmallows&scope=all&room=...&dm=...&author=...&from=...&to=...&limit=20
+	// scope: all (default) | rooms | dms. from/to: dates or datetimes (to is exclusive).
+	// Returns: { "messages": [...], "dm_messages": [...] } — best match first; snippets
+	// wrap matches in <mark></mark>. Only dens the caller belongs to and DMs they're in.
+	se.Router.GET("/api/hearth/search", func(e *core.RequestEvent) error {
+		info, _ := e.RequestInfo()
+		query := e.Request.URL.Query()
+
+		match := ftsMatchQuery(query.Get("q"))
+		if match == "" {
+			return e.BadRequestError("q is required", nil)
+		}
+
+		scope := query.Get("scope")
+		if scope == "" {
+			scope = "all"
+		}
+		if scope != "all" && scope != "rooms" && scope != "dms" {
+			return e.BadRequestError("scope must be all, rooms or dms", nil)
+		}
+
+		filter := searchFilter{
+			Room:   query.Get("room"),
+			DM:     query.Get("dm"),
+			Author: query.Get("author"),
+			Limit:  searchDefaultLimit,
+		}
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return e.BadRequestError("limit must be a positive number", nil)
+			}
+			filter.Limit = min(n, searchMaxLimit)
+		}
+		for param, dst := range map[string]*string{"from": &filter.From, "to": &filter.To} {
+			if s := query.Get(param); s != "" {
+				dt, err := types.ParseDateTime(s)
+				if err != nil || dt.IsZero() {
+					return e.BadRequestError(param+" must be a date or datetime", nil)
+				}
+				*dst = dt.String()
+			}
+		}
+
+		result := map[string][]searchHit{
+			"messages":    {},
+			"dm_messages": {},
+		}
+
+		if scope != "dms" {
+			hits, err := searchDenMessages(e.App, info.Auth.Id, match, filter)
+			if err != nil {
+				return e.InternalServerError("Search failed", err)
+			}
+			result["messages"] = hits
+		}
+
+		if scope != "rooms" {
+			hits, err := searchDmMessages(e.App, info.Auth.Id, match, filter)
+			if err != nil {
+				return e.InternalServerError("Search failed", err)
+			}
+			result["dm_messages"] = hits
+		}
+
+		return e.JSON(200, result)
+	}).Bind(apis.RequireAuth())
+
+	return se.Next()
+}
+
+// searchDenMessages searches den history in rooms userID belongs to.
+func searchDenMessages(app core.App, userID, match string, filter searchFilter) ([]searchHit, error) {
+	q := app.DB().
+		Select(
+			"s.message_id AS id", "s.room AS room", "s.author AS author", "m.author_name AS author_name",
+			"s.created AS created", "bm25(messages_fts) AS rank",
+		).
+		AndSelect("snippet(messages_fts, 0, '"+searchMarkOpen+"', '"+searchMarkClose+"', '…', 12) AS snippet").
+		From("messages_fts").
+		InnerJoin("messages_search s", dbx.NewExp("s.id = messages_fts.rowid")).
+		InnerJoin("messages m", dbx.NewExp("m.id = s.message_id")).
+		Where(dbx.NewExp("messages_fts MATCH {:match}", dbx.Params{"match": match})).
+		AndWhere(dbx.NewExp(`s.room IN (SELECT room FROM room_members WHERE "user" = {:user})`, dbx.Params{"user": userID}))
+
+	if filter.Room != "" {
+		q.AndWhere(dbx.HashExp{"s.room": filter.Room})
+	}
+
+	return runSearch(q, "s", filter)
+}
+
+// searchDmMessages searches the DMs userID takes part in.
+func searchDmMessages(app core.App, userID, match string, filter searchFilter) ([]searchHit, error) {
+	q := app.DB().
+		Select(
+			"s.message_id AS id", "s.dm AS dm", "s.author AS author", "m.author_name AS author_name",
+			"s.created AS created", "bm25(dm_messages_fts) AS rank",
+		).
+		AndSelect("snippet(dm_messages_fts, 0, '"+searchMarkOpen+"', '"+searchMarkClose+"', '…', 12) AS snippet").
+		From("dm_messages_fts").
+		InnerJoin("dm_messages_search s", dbx.NewExp("s.id = dm_messages_fts.rowid")).
+		InnerJoin("dm_messages m", dbx.NewExp("m.id = s.message_id")).
+		Where(dbx.NewExp("dm_messages_fts MATCH {:match}", dbx.Params{"match": match})).
+		AndWhere(dbx.NewExp(
+			"s.dm IN (SELECT id FROM direct_messages WHERE participant_a = {:user} OR participant_b = {:user})",
+			dbx.Params{"user": userID},
+		))
+
+	if filter.DM != "" {
+		q.AndWhere(dbx.HashExp{"s.dm": filter.DM})
+	}
+
+	return runSearch(q, "s", filter)
+}
+
+// runSearch applies the shared author/date filters and limit, best match first.
+func runSearch(q *dbx.SelectQuery, alias string, filter searchFilter) ([]searchHit, error) {
+	if filter.Author != "" {
+		q.AndWhere(dbx.HashExp{alias + ".author": filter.Author})
+	}
+	if filter.From != "" {
+		q.AndWhere(dbx.NewExp(alias+".created >= {:from}", dbx.Params{"from": filter.From}))
+	}
+	if filter.To != "" {
+		q.AndWhere(dbx.NewExp(alias+".created < {:to}", dbx.Params{"to": filter.To}))
+	}
+
+	hits := []searchHit{}
+	err := q.OrderBy("rank ASC").Limit(int64(filter.Limit)).All(&hits)
+	return hits, err
+}
+
+// ftsMatchQuery turns what someone typed into a safe FTS5 query: every word must
+// appear, the last one as a prefix (search-as-you-type). Words are quoted so FTS5
+// operators and punctuation in the input are matched literally, never parsed.
+func ftsMatchQuery(input string) string {
+	words := strings.Fields(input)
+	if len(words) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	terms[len(terms)-1] += "*"
+
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,188 @@
+package hooks
+
+import (
+	"fmt"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// minRoomTTL is the shortest message TTL a room can have (seconds).
+const minRoomTTL = 60
+
+// ttlPolicy is the Homeowner's bounds on room message TTLs, kept on
+// house_settings. All in seconds.
+type ttlPolicy struct {
+	CampfireMin     int
+	CampfireMax     int
+	CampfireDefault int
+	DensMayExpire   bool
+}
+
+// defaultTTLPolicy is what a new House starts with — the bounds the rooms schema
+// enforced before they were configurable.
+var defaultTTLPolicy = ttlPolicy{
+	CampfireMin:     minRoomTTL,
+	CampfireMax:     86400,
+	CampfireDefault: 3600,
+	DensMayExpire:   false,
+}
+
+// RegisterTTLPolicy enforces the House TTL policy on rooms. A campfire created
+// without a TTL gets the House default; one outside the bounds is refused. Dens
+// keep their messages (TTL 0) unless the Homeowner lets them expire. When the
+// Homeowner tightens the policy, existing rooms are brought inside it. Clients
+// read the policy from house_settings, which only the Homeowner can change.
+func RegisterTTLPolicy(app *pocketbase.PocketBase) {
+	bindTTLPolicyHooks(app)
+}
+
+// bindTTLPolicyHooks binds the policy hooks.
+func bindTTLPolicyHooks(app core.App) {
+	app.OnRecordCreate("rooms").BindFunc(func(e *core.RecordEvent) error {
+		policy := loadTTLPolicy(e.App)
+		if e.Record.GetString("type") != "den" && e.Record.GetInt("default_ttl") == 0 {
+			e.Record.Set("default_ttl", policy.CampfireDefault)
+		}
+		if err := policy.check(e.Record.GetString("type"), e.Record.GetInt("default_ttl")); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+
+	app.OnRecordUpdate("rooms").BindFunc(func(e *core.RecordEvent) error {
+		original := e.Record.Original()
+		roomType, ttl := e.Record.GetString("type"), e.Record.GetInt("default_ttl")
+		typeChanged := roomType != original.GetString("type")
+		if !typeChanged && ttl == original.GetInt("default_ttl") {
+			return e.Next()
+		}
+
+		policy := loadTTLPolicy(e.App)
+		// A room switching type without naming a new TTL takes the nearest one that fits
+		if typeChanged && ttl == original.GetInt("default_ttl") {
+			e.Record.Set("default_ttl", policy.fit(roomType, ttl))
+		}
+		if err := policy.check(roomType, e.Record.GetInt("default_ttl")); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+
+	app.OnRecordUpdate("house_settings").BindFunc(func(e *core.RecordEvent) error {
+		policy := ttlPolicyFromSettings(e.Record)
+		if policy.CampfireMin > policy.CampfireMax {
+			return validation.Errors{"campfire_ttl_min": validation.NewError(
+				"validation_ttl_policy_min", "The minimum TTL can't be longer than the maximum",
+			)}
+		}
+		if policy.CampfireDefault < policy.CampfireMin || policy.CampfireDefault > policy.CampfireMax {
+			return validation.Errors{"campfire_ttl_default": validation.NewError(
+				"validation_ttl_policy_default", "The default TTL must be between the minimum and maximum",
+			)}
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterUpdateSuccess("house_settings").BindFunc(func(e *core.RecordEvent) error {
+		if _, err := migrateRoomTTLs(e.App, ttlPolicyFromSettings(e.Record)); err != nil {
+			e.App.Logger().Error("failed to apply TTL policy to rooms", "error", err)
+		}
+		return e.Next()
+	})
+}
+
+// loadTTLPolicy reads the House TTL policy, falling back to defaultTTLPolicy
+// when house_settings hasn't been seeded.
+func loadTTLPolicy(app core.App) ttlPolicy {
+	settings, err := app.FindFirstRecordByFilter("house_settings", "id != ''")
+	if err != nil {
+		return defaultTTLPolicy
+	}
+	return ttlPolicyFromSettings(settings)
+}
+
+// ttlPolicyFromSettings reads the policy off a house_settings record. Bounds
+// that were never set (0) take their default.
+func ttlPolicyFromSettings(settings *core.Record) ttlPolicy {
+	policy := ttlPolicy{
+		CampfireMin:     settings.GetInt("campfire_ttl_min"),
+		CampfireMax:     settings.GetInt("campfire_ttl_max"),
+		CampfireDefault: settings.GetInt("campfire_ttl_default"),
+		DensMayExpire:   settings.GetBool("dens_may_expire"),
+	}
+	if policy.CampfireMin == 0 {
+		policy.CampfireMin = defaultTTLPolicy.CampfireMin
+	}
+	if policy.CampfireMax == 0 {
+		policy.CampfireMax = defaultTTLPolicy.CampfireMax
+	}
+	if policy.CampfireDefault == 0 {
+		policy.CampfireDefault = defaultTTLPolicy.CampfireDefault
+	}
+	return policy
+}
+
+// check reports whether a room of roomType may have a TTL of ttl seconds. A den
+// may always keep its messages (0); any other TTL must sit within the campfire
+// bounds. An empty type is a campfire (the rooms create hook defaults it).
+func (p ttlPolicy) check(roomType string, ttl int) error {
+	if roomType == "den" {
+		if ttl == 0 {
+			return nil
+		}
+		if !p.DensMayExpire {
+			return validation.Errors{"default_ttl": validation.NewError(
+				"validation_ttl_den", "This House keeps den messages — dens can't have a TTL",
+			)}
+		}
+	}
+
+	if ttl < p.CampfireMin || ttl > p.CampfireMax {
+		return validation.Errors{"default_ttl": validation.NewError(
+			"validation_ttl_bounds", fmt.Sprintf("TTL must be between %d and %d seconds", p.CampfireMin, p.CampfireMax),
+		)}
+	}
+	return nil
+}
+
+// fit is the TTL closest to ttl that the policy allows for a room of roomType.
+func (p ttlPolicy) fit(roomType string, ttl int) int {
+	if roomType == "den" && (ttl == 0 || !p.DensMayExpire) {
+		return 0
+	}
+	if ttl == 0 {
+		return p.CampfireDefault
+	}
+	return min(max(ttl, p.CampfireMin), p.CampfireMax)
+}
+
+// migrateRoomTTLs brings every room's TTL inside policy: campfires are clamped
+// to the bounds and, unless dens may expire, dens go back to keeping their
+// messages. Messages already posted keep their expires_at. Returns how many
+// rooms changed.
+func migrateRoomTTLs(app core.App, policy ttlPolicy) (int, error) {
+	rooms, err := app.FindAllRecords("rooms")
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, room := range rooms {
+		ttl := room.GetInt("default_ttl")
+		fitted := policy.fit(room.GetString("type"), ttl)
+		if fitted == ttl {
+			continue
+		}
+
+		room.Set("default_ttl", fitted)
+		if err := app.Save(room); err != nil {
+			app.Logger().Error("failed to apply TTL policy to room", "error", err, "room", room.Id)
+			continue
+		}
+		changed++
+		app.Logger().Info("room TTL adjusted to House policy", "room", room.Id, "from", ttl, "to", fitted)
+	}
+	return changed, nil
+}
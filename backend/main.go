@@ -20,12 +20,29 @@ func main() {
 	hooks.RegisterPragmas(app)
 	hooks.RegisterCollections(app)
 	hooks.RegisterMessageGC(app)
+	hooks.RegisterReactions(app)
+	hooks.RegisterReplies(app)
+	hooks.RegisterSearch(app)
+	hooks.RegisterEdits(app)
+	hooks.RegisterAttachments(app)
+	hooks.RegisterAvatars(app)
+	hooks.RegisterCampfires(app)
+	hooks.RegisterBurnAfterRead(app)
+	hooks.RegisterTTLPolicy(app)
+	hooks.RegisterDmTimers(app)
+	hooks.RegisterDirectMessages(app)
+	hooks.RegisterBlocks(app)
 	hooks.RegisterVacuum(app)
 	hooks.RegisterPresence(app)
 
 	// Phase 2: Auth & Security
 	hooks.RegisterAuth(app)
+	hooks.RegisterRoles(app)
+	hooks.RegisterAdmin(app)
 	hooks.RegisterInvite(app)
+	hooks.RegisterInviteKeys(app)
+	hooks.RegisterKnock(app)
+	hooks.RegisterGuests(app)
 	hooks.RegisterPoW(app)
 	hooks.RegisterLiveKitToken(app)
 	hooks.RegisterRateLimit(app)
@@ -35,6 +52,9 @@ func main() {
 	// Phase 3: Observability
 	hooks.RegisterMetrics(app)
 
+	// CLI: hearth secrets rotate-invite
+	app.RootCmd.AddCommand(hooks.NewSecretsCommand(app))
+
 	// Serve the Hearth SPA from pb_public/ (with SPA index fallback).
 	// PocketBase only auto-serves pb_public when using the prebuilt binary;
 	// when used as a Go framework, we must register it explicitly.
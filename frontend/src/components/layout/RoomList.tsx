@@ -86,7 +86,8 @@ export function RoomList() {
         slug,
         owner: pb.authStore.record?.id,
         type,
-        default_ttl: type === 'den' ? 0 : 3600,
+        // Campfires take the House default TTL (house_settings.campfire_ttl_default)
+        default_ttl: type === 'den' ? 0 : undefined,
         max_participants: type === 'den' ? 25 : 10,
         livekit_room_name: `hearth-${slug}-${Date.now()}`,
       });
@@ -121,27 +122,14 @@ export function RoomList() {
         return;
       }
 
-      // Canonical ordering — sort IDs for unique index
-      const myId = pb.authStore.record?.id ?? '';
-      const [a, b] = [myId, other.id].sort();
-
-      // Find or create DM
-      let dmId: string;
-      try {
-        const existing = await pb
-          .collection('direct_messages')
-          .getFirstListItem(`participant_a = "${a}" && participant_b = "${b}"`);
-        dmId = existing.id;
-      } catch {
-        const created = await pb.collection('direct_messages').create({
-          participant_a: a,
-          participant_b: b,
-        });
-        dmId = created.id;
-      }
+      // The server orders the pair and returns the existing DM if there is one
+      const { dm } = await pb.send<{ dm: { id: string } }>('/api/hearth/dm/open', {
+        method: 'POST',
+        body: { user_id: other.id },
+      });
 
       await fetchDms();
-      navigate(`/dm/${dmId}`);
+      navigate(`/dm/${dm.id}`);
     } catch (err) {
       alert(err instanceof Error ? err.message : 'Failed to start conversation');
     }
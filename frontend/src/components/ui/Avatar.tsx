@@ -1,7 +1,24 @@
+import pb from '@/lib/pocketbase';
+
+type AvatarSize = 'sm' | 'md' | 'lg';
+
+/** The user fields an avatar can be drawn from. */
+export type AvatarUser = {
+  id: string;
+  collectionId?: string;
+  collectionName?: string;
+  /** Uploaded avatar (a 256px square, stored server-side). */
+  avatar?: string;
+  /** Deprecated external image — empty when the House has external avatars off. */
+  avatar_url?: string;
+};
+
 interface AvatarProps {
   name: string;
   src?: string;
-  size?: 'sm' | 'md' | 'lg';
+  /** Picks the uploaded avatar at the right size (ignored when `src` is set). */
+  user?: AvatarUser;
+  size?: AvatarSize;
   active?: boolean;
   className?: string;
 }
@@ -12,17 +29,36 @@ const sizeClasses = {
   lg: 'w-14 h-14 text-base',
 } as const;
 
+// Server-side thumb per size, 2× the rendered size for sharp edges on HiDPI.
+// lg uses the stored 256px square as is.
+const thumbSizes: Record<AvatarSize, string | undefined> = {
+  sm: '64x64',
+  md: '128x128',
+  lg: undefined,
+};
+
+/** URL of a user's avatar for an Avatar of the given size, if they have one. */
+export function avatarSrc(user: AvatarUser, size: AvatarSize = 'md'): string | undefined {
+  if (user.avatar) {
+    const thumb = thumbSizes[size];
+    return pb.files.getURL(user, user.avatar, thumb ? { thumb } : undefined);
+  }
+  return user.avatar_url || undefined;
+}
+
 /**
  * Rounded avatar with initials fallback.
  * Ember glow ring when user is active/speaking.
  */
 export function Avatar({
   name,
-  src,
+  src: srcProp,
+  user,
   size = 'md',
   active = false,
   className = '',
 }: AvatarProps) {
+  const src = srcProp ?? (user ? avatarSrc(user, size) : undefined);
   const initials = name
     .split(' ')
     .map((w) => w[0])
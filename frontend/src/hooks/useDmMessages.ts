@@ -8,13 +8,16 @@ export interface DmMessage {
   author: string;
   author_name: string;
   body: string;
+  type: 'text' | 'system';
+  expires_at: string; // far future unless the DM has a disappearing timer
   created: string;
 }
 
 const DM_PAGE_SIZE = 200;
 
 /**
- * Messages hook for DM conversations — permanent messages.
+ * Messages hook for DM conversations — permanent unless either participant
+ * sets a disappearing timer (direct_messages.disappear_after).
  * Subscribes to `dm_messages` collection, filters by DM ID.
  */
 export function useDmMessages(dmId: string) {
@@ -91,6 +94,8 @@ export function useDmMessages(dmId: string) {
         author: pb.authStore.record?.id ?? '',
         author_name: pb.authStore.record?.['display_name'] ?? 'Wanderer',
         body: text,
+        type: 'text',
+        expires_at: '2099-12-31T23:59:59Z', // server sets the real expiry
         created: new Date().toISOString(),
       };
 
@@ -10,9 +10,13 @@ export interface Message {
   author: string;
   author_name: string;
   expires_at: string;
+  /** Sender-chosen lifetime in seconds (never longer than the room's TTL). */
+  ttl?: number;
+  /** Seconds the message lingers once everyone present has read it (0 = off). */
+  burn_after_read?: number;
   created: string;
   expand?: {
-    author?: { display_name: string; avatar_url: string };
+    author?: { id: string; collectionId: string; display_name: string; avatar: string; avatar_url: string };
   };
 }
 